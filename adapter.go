@@ -17,17 +17,30 @@ command after building:
 			-e LOGSPOUT="ignore" \
 			<REGISTRY>/<CUSTOM_LOGSPOUT>:<VERSION> \
 				./logspout fluentd://<FLUENTD_IP>:<FLUENTD_PORT>
+
+Every setting may also be given as a route option, which takes precedence over
+the environment variable, and defaults may be kept in FLUENTD_CONFIG_FILE:
+	>> ./logspout "fluentd://<FLUENTD_IP>:<FLUENTD_PORT>?async=true&tag_prefix=docker"
+The fluentd-config command lists every setting with its variable and default
+(-schema) and checks a configuration before deploying it. The settings are
+documented on Config and beside the feature they configure.
+
+Programs embedding the adapter use NewAdapterFromConfig or
+NewAdapterWithPoster and shut it down with Close. Only adapters created by
+logspout handle signals and publish expvar counters by default.
 *
 *
 */
 import (
-	"log"
+	"context"
 	"math"
 	"net"
 	"os"
-	"regexp"
+	"runtime/debug"
 	"strconv"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/fluent/fluent-logger-golang/fluent"
 	"github.com/gliderlabs/logspout/router"
@@ -39,7 +52,7 @@ const (
 	defaultBufferLimit = 1024 * 1024
 
 	defaultWriteTimeout = 3
-	defaultRetryWait    = 1000
+	defaultRetryWait    = time.Second
 	defaultMaxRetries   = math.MaxInt32
 )
 
@@ -51,139 +64,644 @@ func getenv(key, fallback string) string {
 	return value
 }
 
-func debug(v ...interface{}) {
-	if os.Getenv("DEBUG") == "true" {
-		log.Println(v...)
-	}
-}
-
 // Adapter is an adapter for streaming JSON to a fluentd collector.
+//
+// An Adapter is safe for concurrent use. Stream may be called several times,
+// also concurrently, and the lines of a container stay in order as long as
+// they arrive on the same log stream. The adapter closes itself once the last
+// running Stream saw its log stream closed. Close, the admin API, signals and
+// reloads may run at any time. Components set up by NewAdapter are either
+// immutable or synchronize themselves; the writer, rules and configuration
+// they replace are guarded by mu.
 type Adapter struct {
-	writer         *fluent.Fluent
-	tagPrefix      string
-	tagSuffixLabel string
+	address      string
+	load         func() (*Config, error)
+	limiter      *rateLimiter
+	hostLimiter  *hostLimiter
+	destinations *destinationRouter
+	mirror       *mirror
+	deduper      *deduper
+	quietWindows *quietWindows
+	startupGuard *startupGuard
+	quotas       *tenantQuotas
+	audit        *dropAudit
+	labels       *labelCache
+	metrics      *routeMetrics
+	containers   *containerStats
+	tags         *tagVolume
+	tracer       *deliveryTracer
+	tee          *recordTee
+	cpu          *cpuLimiter
+	pipeline     *pipeline
+	connections  *connectionEvents
+	supervisor   *writerSupervisor
+	state        *runState
+
+	mu     sync.RWMutex
+	writer Poster
+	rules  *rules
+	config *Config
+
+	// ctx is cancelled by Close, which waits for streams and the background
+	// tasks posting records to return. active counts the running calls of
+	// Stream, guarded by mu.
+	ctx       context.Context
+	cancel    context.CancelFunc
+	streams   sync.WaitGroup
+	tasks     sync.WaitGroup
+	active    int
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // Stream handles a stream of messages from Logspout. Implements router.logAdapter.
+// Stream returns when logstream is closed or the adapter is closed, see Close.
+// When logspout closes the last log stream, the adapter posts a final stats
+// record and closes itself, flushing buffered records.
 func (ad *Adapter) Stream(logstream chan *router.Message) {
-	debug("received message from container")
-	for message := range logstream {
-		debug("container: ", message.Container.ID, message.Container.Name)
-		// Skip if message is empty
-		messageIsEmpty, err := regexp.MatchString("^[[:space:]]*$", message.Data)
-		if messageIsEmpty {
-			debug("Skipping empty message!")
-			continue
+	if !ad.startStream() {
+		return
+	}
+	ad.stream(logstream)
+	if ad.endStream() && ad.ctx.Err() == nil {
+		ad.finish()
+	}
+}
+
+// stream handles messages until logstream or the adapter is closed.
+func (ad *Adapter) stream(logstream chan *router.Message) {
+	logDebug("Streaming messages", "route", ad.address)
+	workers := ad.currentConfig().Workers
+	if workers.StreamBuffer > 0 {
+		logstream = bufferStream(logstream, workers.StreamBuffer, ad.ctx.Done())
+	}
+	if workers.Count > 1 {
+		ad.streamWorkers(logstream, workers)
+		return
+	}
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			if debugEnabled() {
+				logDebug("Received message", "container_id", message.Container.ID,
+					"container_name", message.Container.Name)
+			}
+			ad.handle(ad.ctx, message)
+		case <-ad.ctx.Done():
+			return
 		}
+	}
+}
+
+// handle filters, transforms and forwards a single message, tracing its
+// delivery when selected by TRACE_SAMPLE_RATE.
+func (ad *Adapter) handle(ctx context.Context, message *router.Message) {
+	defer ad.recoverMessage(ctx, message)
+	ad.cpu.wait(ctx)
+	ctx, span := ad.tracer.startDelivery(ctx, ad.address, message)
+	defer span.End()
+	transform := ad.tracer.stage(ctx, "transform", "")
+	defer transform.End()
 
-		// Set tag
-		tag := ""
-		if len(ad.tagPrefix) > 0 {
-			tag = ad.tagPrefix
+	ad.metrics.receive()
+	rules := ad.currentRules()
+	// Skip if message is empty
+	if isBlank(message.Data) {
+		logDebug("Skipping empty message", "container_id", message.Container.ID)
+		selftestDropped(ctx, "empty")
+		return
+	}
+
+	// Skip messages that are not forwarded, cheapest checks first
+	meta := ad.labels.meta(message.Container)
+	labels, options := meta.labels, meta.options
+	tag := ad.pipeline.tags.tag(meta, rules)
+	d := &delivery{
+		message:  message,
+		rules:    rules,
+		meta:     meta,
+		tag:      tag,
+		failures: append(parseFailures(nil), options.errs...),
+	}
+	if reason := ad.pipeline.filters.filter(d); reason != "" {
+		ad.drop(ctx, message, reason)
+		return
+	}
+	writer := ad.destinations.writerFor(labels, ad.primary())
+
+	// Handle settings that failed to parse according to STRICT_MODE
+	if !d.failures.keep(rules.strictMode) {
+		ad.drop(ctx, message, dropParseError)
+		return
+	}
+
+	// Collapse runs of identical lines, reporting the repeats once the run ends
+	unique, repeated := ad.deduper.check(message, tag, writer, time.Now())
+	if repeated != nil {
+		ad.reportRepeats([]*repeatRun{repeated})
+	}
+	if !unique {
+		return
+	}
+
+	// Forward the first lines of a stream unconditionally, otherwise apply
+	// sampling and rate limits
+	d.sampleRate = 1
+	if !ad.startupGuard.guaranteed(message.Container.ID, time.Now()) {
+		var admitted bool
+		d.sampleRate, admitted = ad.admit(ctx, rules, message, labels, options, tag, writer)
+		if !admitted {
+			return
 		}
-		tagSuffix := message.Container.Config.Labels[ad.tagSuffixLabel]
-		if tagSuffix == "" {
-			tagSuffix = message.Container.Name + "-" + 
-message.Container.Config.Hostname
+	} else {
+		// Guaranteed lines still count against their tenant's quota
+		tenant, _ := ad.quotas.check(labels, time.Now())
+		ad.recordQuota(ctx, rules, tenant, len(message.Data), true)
+	}
+
+	// Measure the delay since the container wrote the line
+	d.latency = time.Since(message.Time)
+	ad.metrics.delayed(d.latency)
+
+	// Construct record
+	record, keep := ad.pipeline.records.build(d)
+	if !keep {
+		ad.drop(ctx, message, dropMiddleware)
+		return
+	}
+
+	// Send to fluentd
+	transform.End()
+	posted := ad.post(ctx, writer, tag, message.Time, record)
+	releaseRecord(record)
+	if posted {
+		ad.containers.forwarded(message.Container.Name, time.Now())
+	}
+}
+
+// recoverMessage recovers from a panic while handling a message, so a single
+// bad message is dropped instead of stopping the stream. It must be deferred.
+func (ad *Adapter) recoverMessage(ctx context.Context, message *router.Message) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if message.Container == nil {
+		logError("Panic while handling message without container, dropping it", "panic", r,
+			"stack", string(debug.Stack()))
+		ad.audit.drop(dropPanic)
+		return
+	}
+	logError("Panic while handling message, dropping it", "panic", r, "container_id", message.Container.ID,
+		"container_name", message.Container.Name, "source", message.Source, "bytes", len(message.Data),
+		"stack", string(debug.Stack()))
+	ad.drop(ctx, message, dropPanic)
+}
+
+// isBlank reports whether a line consists of ASCII whitespace only, like
+// ^[[:space:]]*$ but without a regexp on every line.
+func isBlank(data string) bool {
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case ' ', '\t', '\n', '\v', '\f', '\r':
+		default:
+			return false
 		}
-		tag = tag + "." + tagSuffix
-
-		// Construct record
-		record := map[string]string{
-			"log":            message.Data,
-			"container_id":   message.Container.ID,
-			"container_name": message.Container.Name,
-			"source":         message.Source,
+	}
+	return true
+}
+
+// truncateLine cuts a line to at most max bytes without splitting a UTF-8
+// sequence. The result shares the line's memory, so only the kept bytes are
+// copied when the record is encoded.
+func truncateLine(data string, max int) string {
+	cut := max
+	for cut > 0 && !utf8.RuneStart(data[cut]) {
+		cut--
+	}
+	return data[:cut]
+}
+
+// drop accounts a message discarded for the given reason.
+func (ad *Adapter) drop(ctx context.Context, message *router.Message, reason string) {
+	ad.audit.drop(reason)
+	traceDropped(ctx, reason)
+	selftestDropped(ctx, reason)
+	ad.containers.dropped(message.Container.Name)
+}
+
+// admit applies sampling, quiet windows and rate limits to a message. It
+// returns the effective sample rate and whether the message may be forwarded.
+func (ad *Adapter) admit(ctx context.Context, rules *rules, message *router.Message, labels map[string]string,
+	options *containerOptions, tag string, writer Poster) (int, bool) {
+	// Apply sampling, downsampling further inside quiet windows
+	sampleRate := rules.sampler.rateFor(labels, options.sampleRate)
+	if quietRate, found := ad.quietWindows.match(tag, message.Container.Name, time.Now()); found {
+		if quietRate <= 0 {
+			logDebug("Skipping message in quiet window", "container_id", message.Container.ID, "tag", tag)
+			ad.drop(ctx, message, dropQuietWindow)
+			return sampleRate, false
 		}
+		sampleRate *= quietRate
+	}
+	if !rules.sampler.keep(sampleRate) {
+		ad.drop(ctx, message, dropSampling)
+		return sampleRate, false
+	}
 
-		// debug(tag, message.Time, record)
+	// Apply rate limiting, reporting suppressed lines while engaged
+	allowed, suppressed := ad.limiter.allow(message, tag, writer, time.Now())
+	if suppressed > 0 {
+		ad.postSuppressed(ctx, writer, tag, message, suppressed)
+	}
+	if !allowed {
+		ad.drop(ctx, message, dropRateLimit)
+		return sampleRate, false
+	}
 
-		// Send to fluentd
-		err = ad.writer.PostWithTime(tag, message.Time, record)
-		if err != nil {
-			log.Println("fluentd-adapter PostWithTime Error: ", err)
-			continue
+	// Apply host-wide ceilings shared fairly among containers
+	if !ad.hostLimiter.allow(message.Container.ID, len(message.Data), time.Now()) {
+		ad.drop(ctx, message, dropHostRateLimit)
+		return sampleRate, false
+	}
+
+	// Apply tenant quotas, reporting usage periodically
+	tenant, quotaRate := ad.quotas.check(labels, time.Now())
+	forwarded := quotaRate > 0 && rules.sampler.keep(quotaRate)
+	ad.recordQuota(ctx, rules, tenant, len(message.Data), forwarded)
+	if !forwarded {
+		ad.drop(ctx, message, dropTenantQuota)
+		return sampleRate, false
+	}
+	return sampleRate * quotaRate, true
+}
+
+// recordQuota accounts a record of the given size to its tenant, posting the
+// tenant's usage report when one is due.
+func (ad *Adapter) recordQuota(ctx context.Context, rules *rules, tenant string, size int, forwarded bool) {
+	if report := ad.quotas.record(tenant, size, forwarded, time.Now()); report != nil {
+		ad.post(ctx, ad.primary(), rules.tagPrefix+".quota", time.Now(), report.fields())
+	}
+}
+
+// post sends a record to the given writer, or queues it for a destination
+// with a queue, and to the mirror, if any. Both are traced as stages of the
+// delivery in ctx, if any. It reports whether the writer took the record, or
+// its destination queue did.
+func (ad *Adapter) post(ctx context.Context, writer Poster, tag string, t time.Time,
+	record map[string]string) bool {
+	var posted bool
+	if named, ok := writer.(*namedWriter); ok && named.queue != nil {
+		span := ad.tracer.stage(ctx, "enqueue", named.name)
+		if posted = named.enqueue(tag, t, record); !posted {
+			logDebug("Destination queue full, dropping record", "destination", named.name, "tag", tag)
+			ad.audit.drop(dropDestinationOverflow)
 		}
+		span.End()
+	} else {
+		posted = ad.deliver(ctx, writer, tag, t, record) == nil
+	}
+
+	span := ad.tracer.stage(ctx, "enqueue", "")
+	ad.mirror.send(tag, t, record)
+	span.End()
+	return posted
+}
+
+// deliver posts a record to the writer, accounts the outcome and returns the
+// error of the post.
+func (ad *Adapter) deliver(ctx context.Context, writer Poster, tag string, t time.Time,
+	record map[string]string) error {
+	span := ad.tracer.stage(ctx, "post", destinationName(writer))
+	selftest := selftestMark(ctx, record)
+	start := time.Now()
+	err := writer.PostWithTime(tag, t, ad.pipeline.encoder.encode(record))
+	selftest.posted(tag, ad.currentConfig().Writer, err)
+	ad.metrics.posted(destinationName(writer), len(record["log"]), time.Since(start), err)
+	ad.tee.record(destinationName(writer), tag, t, record)
+	if err == nil {
+		ad.tags.forwarded(tag, len(record["log"]))
+	}
+	// Async posts only buffer the record; their outcome is reported to sent
+	if destinationName(writer) == "primary" && (err != nil || !ad.currentConfig().Writer.Async) {
+		ad.sent(err)
+	}
+	traceFailed(span, err)
+	span.End()
+	if err != nil {
+		ad.audit.failed(err)
+		class, destination := errorType(err), destinationName(writer)
+		logSampledError("post:"+destination+":"+class, "PostWithTime failed", "error", err, "class", class,
+			"destination", destination, "tag", tag, "container_id", record["container_id"])
+	}
+	return err
+}
+
+// sent accounts the outcome of writing to the route's own fluentd, which
+// tracks whether the route is connected and recreates a writer that gave up.
+func (ad *Adapter) sent(err error) {
+	ad.metrics.connection(err)
+	ad.connections.posted(err)
+	ad.state.posted(err)
+	ad.superviseWriter(err)
+}
+
+// written accounts the outcome of an async write, which loses the record when
+// it failed. Outcomes of writes to the route's own fluentd also go to sent.
+func (ad *Adapter) written(primary bool, err error) {
+	if err != nil {
+		ad.audit.failed(err)
+	}
+	if primary {
+		ad.sent(err)
 	}
 }
 
+// containerRecord constructs the base record for a message. The record comes
+// from recordPool and should be released once posted.
+func containerRecord(message *router.Message) map[string]string {
+	record := recordPool.Get().(map[string]string)
+	record["log"] = message.Data
+	record["container_id"] = message.Container.ID
+	record["container_name"] = message.Container.Name
+	record["source"] = message.Source
+	return record
+}
+
 // NewAdapter creates a Logspout fluentd adapter instance.
 func NewAdapter(route *router.Route) (router.LogAdapter, error) {
-	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("tcp"))
-	if !found {
-		return nil, errors.New("Unable to find adapter: " + route.Adapter)
+	adapter, err := NewAdapterContext(context.Background(), route)
+	if err != nil {
+		return nil, err
 	}
+	return adapter, nil
+}
 
-	connMaxRetries, err := strconv.Atoi(getenv("CONNECTION_MAX_RETRIES", "10"))
+// newLogspoutAdapter is the adapter factory registered with logspout. Unlike
+// NewAdapter, its adapters handle signals unless HANDLE_SIGNALS=false.
+func newLogspoutAdapter(route *router.Route) (router.LogAdapter, error) {
+	adapter, err := newRouteAdapter(context.Background(), route, true)
 	if err != nil {
 		return nil, err
 	}
-	connRetryWait, err := strconv.Atoi(getenv("CONNECTION_RETRY_WAIT", "1"))
+	return adapter, nil
+}
+
+// NewAdapterContext creates an adapter whose initial connection retries,
+// streaming and CPU_LIMIT pauses end when ctx is done. Cancelling ctx closes
+// the adapter, see Close. Posts already handed to the fluent logger are not
+// interrupted; they end within FLUENTD_WRITE_TIMEOUT.
+func NewAdapterContext(ctx context.Context, route *router.Route) (*Adapter, error) {
+	return newRouteAdapter(ctx, route, false)
+}
+
+// newRouteAdapter creates an adapter for a logspout route, with the defaults
+// of logspout's own adapters if logspout is set.
+func newRouteAdapter(ctx context.Context, route *router.Route, logspout bool) (*Adapter, error) {
+	adapterTransport, found := router.AdapterTransports.Lookup(route.AdapterTransport("tcp"))
+	if !found {
+		return nil, errors.New("Unable to find adapter: " + route.Adapter)
+	}
+
+	config, err := LoadConfig(route.Address, route.Options)
 	if err != nil {
 		return nil, err
 	}
+	if logspout {
+		config.logspoutDefaults()
+	}
+	dial := func() error {
+		_, err := adapterTransport.Dial(route.Address, route.Options)
+		return err
+	}
+	load := func() (*Config, error) {
+		config, err := LoadConfig(route.Address, route.Options)
+		if err == nil && logspout {
+			config.logspoutDefaults()
+		}
+		return config, err
+	}
+	return newAdapter(ctx, route.Address, load, config, dial, nil)
+}
+
+// NewAdapterFromConfig creates an adapter forwarding to cfg.Writer.Address
+// without a logspout route, for programs embedding the pipeline. cfg is
+// usually obtained from LoadConfig and adjusted; in a Config built directly,
+// zero values disable the optional features and an empty StreamFilter
+// forwards all streams. Messages are passed to
+// Stream; Close shuts the adapter down. The configuration is cfg for the
+// adapter's lifetime: it is not reloaded, neither from FLUENTD_CONFIG_FILE nor
+// on SIGHUP or through the admin API.
+func NewAdapterFromConfig(cfg Config) (*Adapter, error) {
+	config := cfg
+	dial := func() error {
+		conn, err := net.DialTimeout(defaultProtocol, config.Writer.Address, config.Writer.WriteTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	return newAdapter(context.Background(), config.Writer.Address, nil, &config, dial, nil)
+}
+
+// NewAdapterWithPoster creates an adapter posting the records of the route's
+// own fluentd to writer instead of connecting to cfg.Writer.Address, so the
+// pipeline runs without a live fluentd, e.g. in tests. Destinations and the
+// mirror, if configured, still connect to their fluentd. As with
+// NewAdapterFromConfig, cfg is not reloaded.
+func NewAdapterWithPoster(cfg Config, writer Poster) (*Adapter, error) {
+	config := cfg
+	return newAdapter(context.Background(), config.Writer.Address, nil, &config, nil, writer)
+}
+
+// newAdapter creates an adapter for the route to address with the given
+// configuration, dialing fluentd with dial until it connects. load re-reads
+// the configuration on reload; with a nil load the adapter is not reloaded. A
+// nil dial skips connecting; a nil writer is created from the configuration.
+func newAdapter(ctx context.Context, address string, load func() (*Config, error), config *Config,
+	dial func() error, writer Poster) (*Adapter, error) {
+	logInfo("Resolved configuration", "route", address, "version", Version, "commit", Commit,
+		"config", config)
+	logDebug("Configuration sources", "route", address, "sources", config.Sources())
+	warnUnknownEnv(config.EnvPrefix)
+	if config.MemoryLimit > 0 {
+		logInfo("Sized fluent logger buffers by memory limit", "route", address,
+			"memory_limit", config.MemoryLimit, "buffer_limit", config.Writer.BufferLimit)
+	}
 
 	// Dial fluentd on given port. Retry on error
-	for i := 0; i <= connMaxRetries; i++ {
-		_, err := transport.Dial(route.Address, route.Options)
+	state := newRunState(address)
+	state.set(stateConnecting, reasonDial)
+	connections := newConnectionEvents(address)
+	for i := 0; i <= config.ConnectionMaxRetries && !config.Writer.DryRun && dial != nil; i++ {
+		err := dial()
+		connections.dialed(err)
 		if err != nil {
-			log.Printf("Error: %v\n", err)
-			if i == connMaxRetries {
+			logError("Unable to connect to fluentd", "address", address, "error", err)
+			if i == config.ConnectionMaxRetries {
+				state.set(stateStopped, eventConnectFailed)
 				return nil, err
 			}
-			log.Printf("Retrying in %d seconds...\n", connRetryWait)
-			time.Sleep(time.Duration(connRetryWait) * time.Second)
+			logInfo("Retrying connection", "address", address, "wait", config.ConnectionRetryWait)
+			select {
+			case <-time.After(config.ConnectionRetryWait):
+			case <-ctx.Done():
+				state.set(stateStopped, "cancelled")
+				return nil, errors.Wrapf(ctx.Err(), "Connecting to fluentd %s cancelled", address)
+			}
 		} else {
-			log.Println("Connectivity successful to fluentd @ " + route.Address)
+			logInfo("Connected to fluentd", "address", address)
 			break
 		}
 	}
 
-	// Construct fluentd config object
-	host, port, err := net.SplitHostPort(route.Address)
-	portNum, err := strconv.Atoi(port)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Invalid fluentd-address %s", route.Address)
+	// What was set up is released again when creating the adapter fails
+	cleanups := []func(){func() { deleteRouteSeries(address) }}
+	fail := func(err error) (*Adapter, error) {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+		return nil, err
 	}
 
-	bufferLimit, err := strconv.Atoi(getenv("FLUENTD_BUFFER_LIMIT", 
-strconv.Itoa(defaultBufferLimit)))
-	if err != nil {
-		return nil, err
+	// Async writes report their outcome to the adapter, which exists by the
+	// time anything was posted
+	var adapter *Adapter
+	if writer == nil {
+		var err error
+		if writer, err = newWriter(config.Writer, func(err error) { adapter.written(true, err) }); err != nil {
+			return nil, err
+		}
+		cleanups = append(cleanups, func() { closeWriter(writer) })
 	}
 
-	retryWait, err := strconv.Atoi(getenv("FLUENTD_RETRY_WAIT", 
-strconv.Itoa(defaultRetryWait)))
+	destinations, err := newDestinationRouter(address, config.Destinations, config.RouteLabel, config.Routes,
+		config.DestinationQueueSize, config.QueueWarn, func(err error) { adapter.written(false, err) })
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
+	cleanups = append(cleanups, destinations.closeWriters)
 
-	maxRetries, err := strconv.Atoi(getenv("FLUENTD_MAX_RETRIES", 
-strconv.Itoa(defaultMaxRetries)))
+	metrics := newRouteMetrics(address)
+	if config.Expvar {
+		metrics.vars.publish(address)
+		cleanups = append(cleanups, func() { metrics.vars.unpublish(address) })
+	}
+	audit := newDropAudit(config.Audit, metrics, newDropAlarm(address, config.DropAlarm))
+	pipeline, err := newPipeline(config)
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
-
-	asyncConnect, err := strconv.ParseBool(getenv("FLUENTD_ASYNC_CONNECT", "false"))
+	cleanups = append(cleanups, pipeline.close)
+	mirror, err := newMirror(config.Mirror, audit, metrics, pipeline.encoder)
 	if err != nil {
-		return nil, err
+		return fail(err)
+	}
+	if mirror != nil {
+		cleanups = append(cleanups, func() {
+			mirror.close()
+			closeWriter(mirror.writer)
+		})
 	}
 
-	subSecondPrecision, err := strconv.ParseBool(getenv("FLUENTD_SUBSECOND_PRECISION", 
-"false"))
+	rules, err := newRules(config)
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
 
-	requestAck, err := strconv.ParseBool(getenv("FLUENTD_REQUEST_ACK", "false"))
+	tracer, err := newDeliveryTracer(config.Tracing)
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
 
-	writeTimeout, err := strconv.Atoi(getenv("FLUENTD_WRITE_TIMEOUT", 
-strconv.Itoa(defaultWriteTimeout)))
+	quietWindows := &quietWindows{}
+	if err := quietWindows.update(config.QuietWindows); err != nil {
+		return fail(err)
+	}
+
+	adapter = &Adapter{
+		writer:       writer,
+		address:      address,
+		load:         load,
+		limiter:      newRateLimiter(config.RateLimit),
+		hostLimiter:  sharedHostLimiter(address, config.HostRateLimit),
+		destinations: destinations,
+		mirror:       mirror,
+		deduper:      newDeduper(config.DedupeWindow),
+		quietWindows: quietWindows,
+		startupGuard: newStartupGuard(config.ForwardFirstLines, config.ForwardFirstLinesGap),
+		quotas:       newTenantQuotas(config.TenantQuota),
+		audit:        audit,
+		labels:       newLabelCache(config.LabelRefreshInterval, newOptionSource(config)),
+		metrics:      metrics,
+		containers:   newContainerStats(address, config.ContainerStatsMax),
+		tags:         newTagVolume(address, config.TagStatsTop),
+		tracer:       tracer,
+		tee:          newRecordTee(config.DebugSampleEvery),
+		cpu:          startCPULimiter(address, config.CPULimit),
+		pipeline:     pipeline,
+		connections:  connections,
+		supervisor:   newWriterSupervisor(address),
+		state:        state,
+		rules:        rules,
+		config:       config,
+	}
+	adapter.ctx, adapter.cancel = context.WithCancel(ctx)
+	cleanups = append(cleanups, adapter.cancel, adapter.labels.close, adapter.cpu.release,
+		func() { unhandleSignals(adapter) })
+	if err := adapter.watchReload(config); err != nil {
+		return fail(err)
+	}
+	registerAdmin(config.Admin, adapter)
+	metrics.health.setTolerance(config.ReadyTolerance)
+	serveMetrics(config.MetricsAddress, metrics.health)
+	servePprof(config.PprofAddress)
+	destinations.run(func(writer Poster, tag string, t time.Time, record map[string]string) {
+		adapter.deliver(context.Background(), writer, tag, t, record)
+	})
+	adapter.background(adapter.runDedupe)
+	adapter.background(adapter.runSuppressed)
+	adapter.background(adapter.runContainerStats)
+	adapter.background(func() { adapter.runStats(config.Stats) })
+	adapter.background(func() { adapter.runStatsd(config.Statsd) })
+	if config.Selftest {
+		go func() { logSelftest(adapter.selftest()) }()
+	}
+	if ctx.Done() != nil {
+		// Also returns once the adapter was closed directly
+		go func() {
+			<-adapter.ctx.Done()
+			adapter.Close()
+		}()
+	}
+	adapter.background(func() {
+		audit.run(adapter.ctx, func(record map[string]string) {
+			adapter.post(context.Background(), adapter.primary(), adapter.currentRules().tagPrefix+".audit",
+				time.Now(), record)
+		})
+	})
+	return adapter, nil
+}
+
+// newWriter creates a fluentd writer from its configuration. In dry-run mode
+// records are printed to stdout instead. Async writers report the outcome of
+// every write to results, if set.
+func newWriter(config WriterConfig, results func(error)) (Poster, error) {
+	if config.DryRun {
+		return &dryRunWriter{address: config.Address}, nil
+	}
+
+	// Construct fluentd config object
+	host, port, err := net.SplitHostPort(config.Address)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrapf(err, "Invalid fluentd-address %s", config.Address)
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid fluentd-address %s", config.Address)
 	}
 
 	fluentConfig := fluent.Config{
@@ -191,31 +709,46 @@ strconv.Itoa(defaultWriteTimeout)))
 		FluentPort:         portNum,
 		FluentNetwork:      defaultProtocol,
 		FluentSocketPath:   "",
-		BufferLimit:        bufferLimit,
-		RetryWait:          retryWait,
-		MaxRetry:           maxRetries,
-		Async:              asyncConnect,
-		SubSecondPrecision: subSecondPrecision,
+		BufferLimit:        config.BufferLimit,
+		RetryWait:          int(config.RetryWait / time.Millisecond),
+		MaxRetry:           config.MaxRetries,
+		Async:              config.Async,
+		SubSecondPrecision: config.SubSecondPrecision,
 
 		// RequestAck currently doesn't work with fluent-bit
 		// Set to false for now if forwarding to fluent-bit.
 		// https://github.com/fluent/fluent-bit/issues/786
-		RequestAck:   requestAck,
-		WriteTimeout: time.Duration(writeTimeout) * time.Second,
+		RequestAck:   config.RequestAck,
+		WriteTimeout: config.WriteTimeout,
+	}
+	if config.Async && results != nil {
+		fluentConfig.AsyncResultCallback = func(_ []byte, err error) { results(err) }
 	}
 	writer, err := fluent.New(fluentConfig)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Unable to create fluentd logger")
 	}
+	if config.RequestAck && !config.Async {
+		return &ackWriter{Fluent: writer}, nil
+	}
+	return writer, nil
+}
 
-	return &Adapter{
-		writer:         writer,
-		tagPrefix:      getenv("TAG_PREFIX", "docker"),
-		tagSuffixLabel: getenv("TAG_SUFFIX_LABEL", ""),
-	}, nil
+// ackWriter serializes the posts of a synchronous writer requesting acks. The
+// fluent logger reads each ack through a new buffered reader, which can take
+// the ack of a concurrent post with it and leave that post waiting forever.
+type ackWriter struct {
+	*fluent.Fluent
+	mu sync.Mutex
 }
 
-func init() {
-	router.AdapterFactories.Register(NewAdapter, "fluentd")
+// PostWithTime posts a record and waits for its ack.
+func (w *ackWriter) PostWithTime(tag string, t time.Time, message interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Fluent.PostWithTime(tag, t, message)
 }
 
+func init() {
+	router.AdapterFactories.Register(newLogspoutAdapter, "fluentd")
+}
@@ -0,0 +1,319 @@
+package fluentd
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// post is a record received by a fakePoster.
+type post struct {
+	tag    string
+	time   time.Time
+	record map[string]string
+}
+
+// fakePoster records posts instead of sending them, failing every post with
+// err when set.
+type fakePoster struct {
+	mu    sync.Mutex
+	posts []post
+	err   error
+}
+
+func (p *fakePoster) PostWithTime(tag string, t time.Time, message interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	// Records return to recordPool once posted, so keep a copy
+	record := make(map[string]string)
+	for key, value := range message.(flatRecord) {
+		record[key] = value
+	}
+	p.posts = append(p.posts, post{tag: tag, time: t, record: record})
+	return nil
+}
+
+// received returns the posts so far.
+func (p *fakePoster) received() []post {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]post(nil), p.posts...)
+}
+
+// newTestAdapter creates an adapter with the given route options posting to
+// writer. It is closed when the test ends.
+func newTestAdapter(t testing.TB, options map[string]string, writer Poster) *Adapter {
+	t.Helper()
+	config, err := LoadConfig("localhost:24224", options)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	ad, err := NewAdapterWithPoster(*config, writer)
+	if err != nil {
+		t.Fatalf("NewAdapterWithPoster: %v", err)
+	}
+	t.Cleanup(func() { ad.Close() })
+	return ad
+}
+
+// testMessage returns a message of the container with the given name and
+// labels.
+func testMessage(name, data string, labels map[string]string) *router.Message {
+	return &router.Message{
+		Container: &docker.Container{
+			ID:     "id-" + name,
+			Name:   name,
+			Config: &docker.Config{Hostname: "host", Image: "image:1", Labels: labels},
+		},
+		Source: "stdout",
+		Data:   data,
+		Time:   time.Unix(1700000000, 0),
+	}
+}
+
+// stream streams the messages through the adapter until they are handled.
+func stream(ad *Adapter, messages ...*router.Message) {
+	logstream := make(chan *router.Message, len(messages))
+	for _, message := range messages {
+		logstream <- message
+	}
+	close(logstream)
+	ad.Stream(logstream)
+}
+
+func TestStreamTagsRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		labels  map[string]string
+		want    string
+	}{
+		{name: "default", want: "docker.web-host"},
+		{name: "prefix", options: map[string]string{"tag_prefix": "app"}, want: "app.web-host"},
+		{
+			name:    "suffix label",
+			options: map[string]string{"tag_suffix_label": "service"},
+			labels:  map[string]string{"service": "checkout"},
+			want:    "docker.checkout",
+		},
+		{
+			name:    "suffix label missing",
+			options: map[string]string{"tag_suffix_label": "service"},
+			want:    "docker.web-host",
+		},
+		{
+			name:   "container option",
+			labels: map[string]string{defaultOptionLabelPrefix + "tag": "custom.tag"},
+			want:   "custom.tag",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writer := &fakePoster{}
+			stream(newTestAdapter(t, test.options, writer), testMessage("web", "hello", test.labels))
+
+			posts := writer.received()
+			if len(posts) != 1 {
+				t.Fatalf("got %d posts, want 1", len(posts))
+			}
+			if posts[0].tag != test.want {
+				t.Errorf("tag = %q, want %q", posts[0].tag, test.want)
+			}
+		})
+	}
+}
+
+func TestStreamRecordFields(t *testing.T) {
+	writer := &fakePoster{}
+	message := testMessage("web", "hello", nil)
+	stream(newTestAdapter(t, nil, writer), message)
+
+	posts := writer.received()
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts, want 1", len(posts))
+	}
+	want := map[string]string{
+		"log":            "hello",
+		"container_id":   "id-web",
+		"container_name": "web",
+		"source":         "stdout",
+	}
+	for key, value := range want {
+		if got := posts[0].record[key]; got != value {
+			t.Errorf("record[%q] = %q, want %q", key, got, value)
+		}
+	}
+	if !posts[0].time.Equal(message.Time) {
+		t.Errorf("time = %v, want %v", posts[0].time, message.Time)
+	}
+}
+
+func TestStreamSkipsMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		message *router.Message
+	}{
+		{name: "empty", message: testMessage("web", "", nil)},
+		{name: "blank", message: testMessage("web", " \t\r\n", nil)},
+		{
+			name:    "excluded",
+			message: testMessage("web", "hello", map[string]string{defaultExcludeLabel: "true"}),
+		},
+		{
+			name:    "stream filter",
+			options: map[string]string{"stream_filter": "stdout"},
+			message: func() *router.Message {
+				message := testMessage("web", "hello", nil)
+				message.Source = "stderr"
+				return message
+			}(),
+		},
+		{
+			name:    "health check",
+			options: map[string]string{"filter_healthchecks": "true"},
+			message: testMessage("web", `GET /health HTTP/1.1" 200`, nil),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writer := &fakePoster{}
+			stream(newTestAdapter(t, test.options, writer), test.message, testMessage("api", "kept", nil))
+
+			posts := writer.received()
+			if len(posts) != 1 || posts[0].record["log"] != "kept" {
+				t.Errorf("got posts %v, want only the kept line", posts)
+			}
+		})
+	}
+}
+
+func TestStreamPostErrors(t *testing.T) {
+	writer := &fakePoster{err: errors.New("fluent#write: failed to write after 3 attempts")}
+	ad := newTestAdapter(t, nil, writer)
+
+	logstream := make(chan *router.Message, 2)
+	logstream <- testMessage("web", "one", nil)
+	logstream <- testMessage("web", "two", nil)
+	done := make(chan struct{})
+	go func() {
+		ad.Stream(logstream)
+		close(done)
+	}()
+	close(logstream)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not return after failed posts")
+	}
+
+	if err := ad.metrics.health.ready(time.Now().Add(time.Hour)); err == nil {
+		t.Error("route is ready after failed posts")
+	}
+	if stat := ad.containers.snapshot()["web"]; stat.Forwarded != 0 {
+		t.Errorf("forwarded = %d after failed posts, want 0", stat.Forwarded)
+	}
+}
+
+func TestStreamRecoversFromPanics(t *testing.T) {
+	writer := &fakePoster{}
+	broken := testMessage("web", "hello", nil)
+	broken.Container.Config = nil
+	stream(newTestAdapter(t, nil, writer), broken, testMessage("api", "kept", nil))
+
+	posts := writer.received()
+	if len(posts) != 1 || posts[0].record["log"] != "kept" {
+		t.Errorf("got posts %v, want only the kept line", posts)
+	}
+}
+
+func TestCloseStopsStream(t *testing.T) {
+	ad := newTestAdapter(t, nil, &fakePoster{})
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		ad.Stream(logstream)
+		close(done)
+	}()
+
+	if err := ad.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not return after Close")
+	}
+	// A closed adapter returns at once
+	ad.Stream(logstream)
+}
+
+func FuzzIsBlank(f *testing.F) {
+	for _, seed := range []string{"", " ", " \t\r\n\v\f", "x", " x ", " ", "\x85", "\xff"} {
+		f.Add(seed)
+	}
+	blank := regexp.MustCompile(`^[[:space:]]*$`)
+	f.Fuzz(func(t *testing.T, data string) {
+		if got, want := isBlank(data), blank.MatchString(data); got != want {
+			t.Errorf("isBlank(%q) = %v, want %v", data, got, want)
+		}
+	})
+}
+
+func FuzzTruncateLine(f *testing.F) {
+	f.Add("hello world", 5)
+	f.Add("ünïcödé", 3)
+	f.Add("日本語", 4)
+	f.Add("\x80\x80\x80", 2)
+	f.Fuzz(func(t *testing.T, data string, max int) {
+		// Callers only truncate lines longer than max
+		if max < 0 || max >= len(data) {
+			return
+		}
+		got := truncateLine(data, max)
+		if !strings.HasPrefix(data, got) || len(got) > max {
+			t.Fatalf("truncateLine(%q, %d) = %q", data, max, got)
+		}
+		if utf8.ValidString(data) && (!utf8.ValidString(got) || len(got) < max-utf8.UTFMax+1) {
+			t.Fatalf("truncateLine(%q, %d) = %q splits or drops a rune", data, max, got)
+		}
+	})
+}
+
+func BenchmarkIsBlank(b *testing.B) {
+	lines := map[string]string{
+		"line":  `2024-05-01T12:00:00Z INFO request served path=/api/orders status=200 duration=12ms`,
+		"blank": strings.Repeat(" ", 60) + "\r\n",
+	}
+	for name, line := range lines {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				isBlank(line)
+			}
+		})
+	}
+}
+
+func TestNewAdapterReleasesOnFailure(t *testing.T) {
+	config, err := LoadConfig("failing:24224", map[string]string{"expvar": "true", "wasm_file": "/nonexistent.wasm"})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if _, err := NewAdapterWithPoster(*config, &fakePoster{}); err == nil {
+		t.Fatal("adapter created with a missing WASM module")
+	}
+	if vars := expvarRoutes.Get("failing:24224"); vars != nil {
+		t.Errorf("counters of the failed adapter still published: %v", vars)
+	}
+}
@@ -0,0 +1,269 @@
+package fluentd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AdminConfig configures the opt-in admin HTTP API.
+type AdminConfig struct {
+	Address string
+	Token   string
+}
+
+// adminServer serves the admin API of all adapters configured with the same
+// admin address. Every request must carry the token as a bearer token.
+//
+//	GET  /config                 effective configuration of every route
+//	GET  /containers             delivery statistics of every container
+//	GET  /tags                   volume of the top tags, largest first
+//	GET  /state                  run state of every route and when it was entered
+//	POST /sampling?rate=N        set the global sample rate
+//	POST /filter?expression=E    set the global filter expression
+//	POST /reload                 reload the configuration
+//	POST /reconnect              flush and reconnect to fluentd
+//	POST /selftest               send a synthetic record and report its outcome
+//
+// POST requests apply to all routes, or to one with ?route=<address>. Changes
+// to sampling and filters last until the next configuration reload.
+type adminServer struct {
+	token    string
+	mu       sync.Mutex
+	adapters []*Adapter
+}
+
+var (
+	adminServersMu sync.Mutex
+	adminServers   = make(map[string]*adminServer)
+)
+
+// registerAdmin adds an adapter to the admin server listening on the
+// configured address, starting the server on first use.
+func registerAdmin(config AdminConfig, ad *Adapter) {
+	if config.Address == "" {
+		return
+	}
+
+	adminServersMu.Lock()
+	defer adminServersMu.Unlock()
+
+	server, found := adminServers[config.Address]
+	if !found {
+		server = &adminServer{token: config.Token}
+		adminServers[config.Address] = server
+		go func() {
+			err := http.ListenAndServe(config.Address, server.handler())
+			logError("Admin API stopped", "address", config.Address, "error", err)
+		}()
+	} else if server.token != config.Token {
+		logWarn("Admin API already serves another route with a different token, which stays in effect",
+			"address", config.Address)
+	}
+	server.mu.Lock()
+	server.adapters = append(server.adapters, ad)
+	server.mu.Unlock()
+}
+
+// unregisterAdmin removes a closed adapter from the admin server it was added
+// to. The server keeps listening for the adapters of other routes.
+func unregisterAdmin(config AdminConfig, ad *Adapter) {
+	adminServersMu.Lock()
+	server, found := adminServers[config.Address]
+	adminServersMu.Unlock()
+	if !found {
+		return
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for i, registered := range server.adapters {
+		if registered == ad {
+			server.adapters = append(server.adapters[:i:i], server.adapters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *adminServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", s.config)
+	mux.HandleFunc("/containers", s.containers)
+	mux.HandleFunc("/tags", s.tags)
+	mux.HandleFunc("/state", s.state)
+	mux.HandleFunc("/selftest", s.selftest)
+	mux.HandleFunc("/sampling", s.post(func(ad *Adapter, r *http.Request) error {
+		rate, err := strconv.Atoi(r.URL.Query().Get("rate"))
+		if err != nil || rate < 1 {
+			return errBadRequest("rate must be a positive integer")
+		}
+		ad.setSampleRate(rate)
+		return nil
+	}))
+	mux.HandleFunc("/filter", s.post(func(ad *Adapter, r *http.Request) error {
+		return ad.setFilterExpression(r.URL.Query().Get("expression"))
+	}))
+	mux.HandleFunc("/reload", s.post(func(ad *Adapter, r *http.Request) error {
+		return ad.reload()
+	}))
+	mux.HandleFunc("/reconnect", s.post(func(ad *Adapter, r *http.Request) error {
+		return ad.reconnect()
+	}))
+	return s.authenticate(mux)
+}
+
+// authenticate rejects requests without the admin token.
+func (s *adminServer) authenticate(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// config writes the redacted configuration of every route, by address.
+func (s *adminServer) config(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	configs := make(map[string]json.RawMessage)
+	for _, ad := range s.routes("") {
+		configs[ad.address] = json.RawMessage(ad.currentConfig().String())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(configs)
+}
+
+// containers writes the delivery statistics of every container, by route
+// address and container name. With ?name=<container> only that container is
+// included.
+func (s *adminServer) containers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	routes := make(map[string]map[string]containerStat)
+	for _, ad := range s.routes(r.URL.Query().Get("route")) {
+		stats := ad.containers.snapshot()
+		if name != "" {
+			stat, found := stats[name]
+			stats = map[string]containerStat{}
+			if found {
+				stats[name] = stat
+			}
+		}
+		routes[ad.address] = stats
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(routes)
+}
+
+// tags writes the volume of the top tags of every route, by route address.
+func (s *adminServer) tags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	routes := make(map[string][]tagStat)
+	for _, ad := range s.routes(r.URL.Query().Get("route")) {
+		routes[ad.address] = ad.tags.snapshot()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(routes)
+}
+
+// state writes the run state of every route, by route address.
+func (s *adminServer) state(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	type routeState struct {
+		State string    `json:"state"`
+		Since time.Time `json:"since"`
+	}
+	routes := make(map[string]routeState)
+	for _, ad := range s.routes(r.URL.Query().Get("route")) {
+		state, since := ad.state.current()
+		routes[ad.address] = routeState{State: state, Since: since}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(routes)
+}
+
+// selftest sends a self-test record through the selected routes and writes
+// the results.
+func (s *adminServer) selftest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	routes := s.routes(r.URL.Query().Get("route"))
+	if len(routes) == 0 {
+		http.Error(w, "no such route", http.StatusNotFound)
+		return
+	}
+	var results []*selftestResult
+	for _, ad := range routes {
+		result := ad.selftest()
+		logSelftest(result)
+		results = append(results, result)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// post handles a POST request by applying change to the selected routes.
+func (s *adminServer) post(change func(*Adapter, *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		routes := s.routes(r.URL.Query().Get("route"))
+		if len(routes) == 0 {
+			http.Error(w, "no such route", http.StatusNotFound)
+			return
+		}
+		for _, ad := range routes {
+			if err := change(ad, r); err != nil {
+				status := http.StatusInternalServerError
+				if _, ok := err.(errBadRequest); ok {
+					status = http.StatusBadRequest
+				}
+				http.Error(w, err.Error(), status)
+				return
+			}
+		}
+		logInfo("Admin API request applied", "request", r.URL.String())
+		io.WriteString(w, "ok\n")
+	}
+}
+
+// routes returns the adapters with the given address, or all of them.
+func (s *adminServer) routes(address string) []*Adapter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var routes []*Adapter
+	for _, ad := range s.adapters {
+		if address == "" || ad.address == address {
+			routes = append(routes, ad)
+		}
+	}
+	return routes
+}
+
+// errBadRequest is an invalid admin request.
+type errBadRequest string
+
+func (e errBadRequest) Error() string { return string(e) }
@@ -0,0 +1,122 @@
+package fluentd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminMutations(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+		check      func(ad *Adapter) interface{}
+		want       interface{}
+	}{
+		{
+			name:       "sampling",
+			method:     http.MethodPost,
+			path:       "/sampling?rate=10",
+			token:      "secret",
+			wantStatus: http.StatusOK,
+			check:      func(ad *Adapter) interface{} { return ad.currentRules().sampler.rate },
+			want:       10,
+		},
+		{
+			name:       "invalid sampling",
+			method:     http.MethodPost,
+			path:       "/sampling?rate=0",
+			token:      "secret",
+			wantStatus: http.StatusBadRequest,
+			check:      func(ad *Adapter) interface{} { return ad.currentRules().sampler.rate },
+			want:       1,
+		},
+		{
+			name:       "filter",
+			method:     http.MethodPost,
+			path:       `/filter?expression=source+%3D%3D+%22stderr%22`,
+			token:      "secret",
+			wantStatus: http.StatusOK,
+			check:      func(ad *Adapter) interface{} { return ad.currentRules().filter.expression },
+			want:       `source == "stderr"`,
+		},
+		{
+			name:       "invalid filter",
+			method:     http.MethodPost,
+			path:       "/filter?expression=msg+%3D%3D",
+			token:      "secret",
+			wantStatus: http.StatusBadRequest,
+			check:      func(ad *Adapter) interface{} { return ad.currentRules().filter.expression },
+			want:       "",
+		},
+		{
+			name:       "route",
+			method:     http.MethodPost,
+			path:       "/sampling?rate=10&route=elsewhere:24224",
+			token:      "secret",
+			wantStatus: http.StatusNotFound,
+			check:      func(ad *Adapter) interface{} { return ad.currentRules().sampler.rate },
+			want:       1,
+		},
+		{
+			name:       "wrong token",
+			method:     http.MethodPost,
+			path:       "/sampling?rate=10",
+			token:      "guess",
+			wantStatus: http.StatusUnauthorized,
+			check:      func(ad *Adapter) interface{} { return ad.currentRules().sampler.rate },
+			want:       1,
+		},
+		{
+			name:       "get",
+			method:     http.MethodGet,
+			path:       "/sampling?rate=10",
+			token:      "secret",
+			wantStatus: http.StatusMethodNotAllowed,
+			check:      func(ad *Adapter) interface{} { return ad.currentRules().sampler.rate },
+			want:       1,
+		},
+		{
+			name:       "reload",
+			method:     http.MethodPost,
+			path:       "/reload",
+			token:      "secret",
+			wantStatus: http.StatusOK,
+			check:      func(ad *Adapter) interface{} { return ad.currentRules().tagPrefix },
+			want:       "reloaded",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ad := newForwardAdapter(t, newFakeFluentd(t, nil, 0), nil)
+			t.Setenv("TAG_PREFIX", "reloaded")
+			server := &adminServer{token: "secret", adapters: []*Adapter{ad}}
+
+			request := httptest.NewRequest(test.method, test.path, nil)
+			request.Header.Set("Authorization", "Bearer "+test.token)
+			response := httptest.NewRecorder()
+			server.handler().ServeHTTP(response, request)
+			if response.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d: %s", response.Code, test.wantStatus, response.Body)
+			}
+			if got := test.check(ad); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAdminReloadEmbedded(t *testing.T) {
+	ad := newTestAdapter(t, nil, &fakePoster{})
+	server := &adminServer{token: "secret", adapters: []*Adapter{ad}}
+	request := httptest.NewRequest(http.MethodPost, "/reload", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	response := httptest.NewRecorder()
+	server.handler().ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", response.Code, http.StatusBadRequest)
+	}
+}
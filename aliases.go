@@ -0,0 +1,36 @@
+package fluentd
+
+// optionAliases maps the option names of Docker's fluentd log driver to the
+// route options of this adapter, easing migration from the native driver.
+var optionAliases = map[string]string{
+	"fluentd-async":                "async",
+	"fluentd-async-connect":        "async",
+	"fluentd-buffer-limit":         "buffer_limit",
+	"fluentd-max-retries":          "max_retries",
+	"fluentd-request-ack":          "request_ack",
+	"fluentd-retry-wait":           "retry_wait",
+	"fluentd-sub-second-precision": "subsecond_precision",
+}
+
+// envAliases maps variables of this adapter to the alias named after the
+// corresponding log driver option.
+var envAliases = map[string]string{
+	"FLUENTD_ASYNC_CONNECT":       "FLUENTD_ASYNC",
+	"FLUENTD_SUBSECOND_PRECISION": "FLUENTD_SUB_SECOND_PRECISION",
+}
+
+// canonicalOptions returns options with log driver names replaced by the
+// adapter's own. When both are given, the adapter's name wins.
+func canonicalOptions(options map[string]string) map[string]string {
+	canonical := make(map[string]string, len(options))
+	for key, value := range options {
+		if name, found := optionAliases[key]; found {
+			if _, set := options[name]; set {
+				continue
+			}
+			key = name
+		}
+		canonical[key] = value
+	}
+	return canonical
+}
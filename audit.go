@@ -0,0 +1,128 @@
+package fluentd
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultAuditInterval = 60
+
+// Drop reasons counted by the audit.
+const (
+	dropExcluded            = "excluded"
+	dropStreamFilter        = "stream_filter"
+	dropHealthCheck         = "health_check"
+	dropImageDigest         = "image_digest"
+	dropFilterExpression    = "filter_expression"
+	dropParseError          = "parse_error"
+	dropQuietWindow         = "quiet_window"
+	dropSampling            = "sampling"
+	dropRateLimit           = "rate_limit"
+	dropHostRateLimit       = "host_rate_limit"
+	dropTenantQuota         = "tenant_quota"
+	dropMirrorOverflow      = "mirror_overflow"
+	dropDestinationOverflow = "destination_overflow"
+	dropMiddleware          = "middleware"
+	dropPanic               = "panic"
+
+	// dropPostPrefix prefixes the error class of records lost to a failed
+	// post, such as post_buffer_overflow or post_max_retries, see errorType.
+	dropPostPrefix = "post_"
+)
+
+// dropAudit counts records discarded by each rule and records lost to failed
+// posts. When AUDIT_DROPS is
+// enabled, the counts are summarized into an audit record every interval so
+// it is possible to prove what was discarded and why.
+type dropAudit struct {
+	mu       sync.Mutex
+	counts   map[string]int64
+	enabled  bool
+	interval time.Duration
+	metrics  *routeMetrics
+	alarm    *dropAlarm
+}
+
+// newDropAudit creates an audit from the audit configuration, feeding drops
+// to the alarm, if any.
+func newDropAudit(config AuditConfig, metrics *routeMetrics, alarm *dropAlarm) *dropAudit {
+	return &dropAudit{
+		counts:   make(map[string]int64),
+		enabled:  config.Enabled,
+		interval: config.Interval,
+		metrics:  metrics,
+		alarm:    alarm,
+	}
+}
+
+// drop counts a record discarded for the given reason.
+func (a *dropAudit) drop(reason string) {
+	a.metrics.dropped(reason)
+	a.mu.Lock()
+	a.counts[reason]++
+	a.mu.Unlock()
+	a.alarm.count(reason, time.Now())
+}
+
+// failed counts a record lost to a failed post. Unlike drops, failed posts
+// are already counted by routeMetrics.posted.
+func (a *dropAudit) failed(err error) {
+	reason := dropPostPrefix + errorType(err)
+	a.mu.Lock()
+	a.counts[reason]++
+	a.mu.Unlock()
+	a.alarm.count(reason, time.Now())
+}
+
+// run emits an audit record through post every interval in which records
+// were dropped, until ctx is done.
+func (a *dropAudit) run(ctx context.Context, post func(record map[string]string)) {
+	if !a.enabled || a.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if record := a.summary(); record != nil {
+				post(record)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// summary returns the counts since the last summary as record fields and
+// resets them, or nil when nothing was dropped.
+func (a *dropAudit) summary() map[string]string {
+	a.mu.Lock()
+	counts := a.counts
+	a.counts = make(map[string]int64)
+	a.mu.Unlock()
+
+	if len(counts) == 0 {
+		return nil
+	}
+	reasons := make([]string, 0, len(counts))
+	var total int64
+	for reason, count := range counts {
+		reasons = append(reasons, reason)
+		total += count
+	}
+	sort.Strings(reasons)
+
+	record := map[string]string{
+		"log":              "dropped " + strconv.FormatInt(total, 10) + " records",
+		"interval_seconds": strconv.Itoa(int(a.interval.Seconds())),
+		"dropped_total":    strconv.FormatInt(total, 10),
+	}
+	for _, reason := range reasons {
+		record["dropped_"+reason] = strconv.FormatInt(counts[reason], 10)
+	}
+	return record
+}
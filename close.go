@@ -0,0 +1,163 @@
+package fluentd
+
+import (
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultDrainTimeout = 10
+
+	// drainPollInterval is how often Close checks whether queues drained.
+	drainPollInterval = 10 * time.Millisecond
+)
+
+// Close shuts the adapter down: it cancels the adapter's context, stopping
+// Stream, waits up to DRAIN_TIMEOUT
+// for messages being handled and the destination and mirror queues to drain,
+// then closes the route's writers, which flushes the records buffered by the
+// fluent logger. Records still queued at the deadline are lost.
+//
+// Close is safe to call concurrently and more than once; every call waits
+// for the first to finish and returns its result. Implements io.Closer.
+func (ad *Adapter) Close() error {
+	ad.closeOnce.Do(func() {
+		ad.closeErr = ad.shutdown()
+	})
+	return ad.closeErr
+}
+
+// shutdown implements Close.
+func (ad *Adapter) shutdown() error {
+	timeout := ad.currentConfig().DrainTimeout
+	deadline := time.Now().Add(timeout)
+	logInfo("Closing adapter", "route", ad.address, "drain_timeout", timeout)
+
+	ad.state.set(stateDraining, "close")
+	ad.mu.Lock()
+	ad.cancel()
+	ad.mu.Unlock()
+	unhandleSignals(ad)
+	unregisterAdmin(ad.currentConfig().Admin, ad)
+
+	if !waitTimeout(ad.streams.Wait, time.Until(deadline)) {
+		logWarn("Messages still being handled at the drain deadline", "route", ad.address)
+	}
+	if !waitTimeout(ad.tasks.Wait, time.Until(deadline)) {
+		logWarn("Background tasks still running at the drain deadline", "route", ad.address)
+	}
+	ad.reportRepeats(ad.deduper.flush())
+	ad.reportSuppressed(ad.limiter.flush())
+	ad.labels.close()
+	ad.pipeline.close()
+	for ad.destinations.pending()+ad.mirror.pending() > 0 {
+		if time.Now().After(deadline) {
+			logWarn("Queued records lost at the drain deadline", "route", ad.address,
+				"destinations", ad.destinations.pending(), "mirror", ad.mirror.pending())
+			break
+		}
+		time.Sleep(drainPollInterval)
+	}
+	ad.destinations.close()
+	ad.mirror.close()
+	unserveMetrics(ad.currentConfig().MetricsAddress, ad.metrics.health)
+	ad.metrics.vars.unpublish(ad.address)
+
+	writers := map[string]Poster{"primary": ad.primary()}
+	for _, writer := range ad.destinations.writers {
+		writers[writer.name] = writer.Poster
+	}
+	if ad.mirror != nil {
+		writers["mirror"] = ad.mirror.writer
+	}
+	var first error
+	for name, writer := range writers {
+		closer, ok := writer.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil {
+			err = errors.Wrapf(err, "Unable to close fluentd writer %s", name)
+			logError("Close failed", "address", ad.address, "error", err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	ad.containers.close()
+	ad.cpu.release()
+	ad.state.set(stateStopped, "close")
+	deleteRouteSeries(ad.address)
+	return first
+}
+
+// closeWriter closes writer if it is an io.Closer, ignoring errors, for
+// writers that never posted a record.
+func closeWriter(writer Poster) {
+	if closer, ok := writer.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// finish flushes and closes the adapter once logspout closed its log stream,
+// after posting a last stats record when STATS_INTERVAL is set.
+func (ad *Adapter) finish() {
+	logInfo("Log stream closed, flushing", "route", ad.address)
+	if stats := ad.currentConfig().Stats; stats.Interval > 0 {
+		ad.postStats(stats)
+	}
+	ad.Close()
+}
+
+// background runs task in its own goroutine, which Close waits for before
+// closing the writers. Tasks return once the adapter's context is done.
+func (ad *Adapter) background(task func()) {
+	ad.tasks.Add(1)
+	go func() {
+		defer ad.tasks.Done()
+		task()
+	}()
+}
+
+// startStream registers a call of Stream, which must call endStream when it
+// returns. It reports false once the adapter is closed.
+func (ad *Adapter) startStream() bool {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	if ad.ctx.Err() != nil {
+		return false
+	}
+	ad.streams.Add(1)
+	ad.active++
+	ad.state.streaming()
+	return true
+}
+
+// endStream unregisters a call of Stream, reporting whether it was the last
+// one running.
+func (ad *Adapter) endStream() bool {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	ad.active--
+	ad.streams.Done()
+	return ad.active == 0
+}
+
+// waitTimeout calls wait and reports whether it returned within timeout.
+func waitTimeout(wait func(), timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,161 @@
+package fluentd
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// feed sends messages of the named container to logstream until done is
+// closed.
+func feed(logstream chan<- *router.Message, name string, done <-chan struct{}) {
+	for i := 0; ; i++ {
+		select {
+		case logstream <- testMessage(name, "line "+strconv.Itoa(i), nil):
+		case <-done:
+			return
+		}
+	}
+}
+
+// TestConcurrentShutdown runs Stream on several log streams while the
+// configuration is reloaded, the writer is replaced and the adapter is
+// closed, for the race detector to check their synchronization.
+func TestConcurrentShutdown(t *testing.T) {
+	fluentd := newFakeFluentd(t, nil, 0)
+	ad := newForwardAdapter(t, fluentd, map[string]string{"request_ack": "true", "workers": "2"})
+
+	done := make(chan struct{})
+	var feeders, streams, churn sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		logstream := make(chan *router.Message)
+		feeders.Add(1)
+		go func(name string) {
+			defer feeders.Done()
+			feed(logstream, name, done)
+		}("web-" + strconv.Itoa(i))
+		streams.Add(1)
+		go func() {
+			defer streams.Done()
+			ad.Stream(logstream)
+		}()
+	}
+	// Restored when the test ends, after the churn below stopped
+	t.Setenv("TAG_PREFIX", "reload")
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(done) }) }
+	t.Cleanup(func() {
+		stop()
+		churn.Wait()
+	})
+	churn.Add(2)
+	go func() {
+		defer churn.Done()
+		for i := 0; ; i++ {
+			os.Setenv("TAG_PREFIX", "reload"+strconv.Itoa(i%2))
+			if err := ad.reload(); err != nil {
+				t.Errorf("reload: %v", err)
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	go func() {
+		defer churn.Done()
+		for {
+			// Fails once the adapter is closed
+			if err := ad.replaceWriter(); err != nil {
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	closed := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { closed <- ad.Close() }()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-closed:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Close did not return")
+		}
+	}
+	if !waitTimeout(streams.Wait, 5*time.Second) {
+		t.Fatal("Stream did not return after Close")
+	}
+	stop()
+	feeders.Wait()
+	churn.Wait()
+
+	if err := ad.replaceWriter(); err == nil {
+		t.Error("replaceWriter succeeded after Close")
+	}
+	if len(fluentd.received()) == 0 {
+		t.Error("no records forwarded")
+	}
+}
+
+// TestStreamAfterClose checks that streams started while the adapter closes
+// return rather than block.
+func TestStreamAfterClose(t *testing.T) {
+	ad := newTestAdapter(t, nil, &fakePoster{})
+	var streams sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		streams.Add(1)
+		go func() {
+			defer streams.Done()
+			ad.Stream(make(chan *router.Message))
+		}()
+	}
+	ad.Close()
+	if !waitTimeout(streams.Wait, 5*time.Second) {
+		t.Fatal("Stream did not return after Close")
+	}
+}
+
+func TestCloseDeletesRouteSeries(t *testing.T) {
+	config, err := LoadConfig("series:24224", map[string]string{"tag_stats_top": "5"})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	ad, err := NewAdapterWithPoster(*config, &fakePoster{})
+	if err != nil {
+		t.Fatalf("NewAdapterWithPoster: %v", err)
+	}
+	ad.drop(context.Background(), testMessage("web", "a", nil), dropSampling)
+	stream(ad, testMessage("web", "b", nil))
+	if err := ad.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "route" && label.GetValue() == "series:24224" {
+					t.Errorf("series of %s left for the closed route", family.GetName())
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Command fluentd-config checks the fluentd adapter configuration given by the
+// environment and config file, so CI can lint it before deploy:
+//
+//	fluentd-config -address fluentd:24224 -options "async=true&profile=debug"
+//
+// With -schema it prints every supported setting with its default instead, and
+// with -sources it prints where each resolved setting came from.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+
+	fluentd "github.com/Kouba91/logspout-fluentd-test"
+)
+
+func main() {
+	schema := flag.Bool("schema", false, "print the supported settings with their defaults")
+	address := flag.String("address", "localhost:24224", "fluentd address of the route")
+	options := flag.String("options", "", "route options as a query string")
+	sources := flag.Bool("sources", false, "print the source of every resolved setting")
+	flag.Parse()
+
+	if *schema {
+		if err := fluentd.PrintSchema(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	query, err := url.ParseQuery(*options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid route options:", err)
+		os.Exit(2)
+	}
+	routeOptions := make(map[string]string, len(query))
+	for key := range query {
+		routeOptions[key] = query.Get(key)
+	}
+	if err := fluentd.Validate(*address, routeOptions); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *sources {
+		config, _ := fluentd.LoadConfig(*address, routeOptions)
+		printSources(config.Sources())
+	}
+	fmt.Println("fluentd adapter configuration is valid")
+}
+
+func printSources(sources map[string]string) {
+	options := make([]string, 0, len(sources))
+	for option := range sources {
+		options = append(options, option)
+	}
+	sort.Strings(options)
+	for _, option := range options {
+		fmt.Printf("%s\t%s\n", option, sources[option])
+	}
+}
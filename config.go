@@ -0,0 +1,742 @@
+package fluentd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultConfigReloadInterval = 30
+
+	// fileSuffix marks the variant of an environment variable naming a file
+	// that holds the value, e.g. FLUENTD_SHARED_KEY_FILE.
+	fileSuffix = "_FILE"
+)
+
+// Config is the fully resolved configuration of an adapter.
+type Config struct {
+	ConfigFile           string
+	ConfigReloadInterval time.Duration
+	Profile              string
+	EnvPrefix            string
+
+	TagPrefix      string
+	TagSuffixLabel string
+
+	ConnectionMaxRetries int
+	ConnectionRetryWait  time.Duration
+
+	Writer               WriterConfig
+	Destinations         []DestinationConfig
+	RouteLabel           string
+	Routes               map[string]string
+	Mirror               *MirrorConfig
+	DestinationQueueSize int
+
+	// QueueWarn are the utilization percentages of the destination and worker
+	// queues that log a warning when crossed.
+	QueueWarn []int
+
+	FilterHealthchecks    bool
+	StreamFilter          string
+	StreamFilterLabel     string
+	FilterExpression      string
+	FilterExpressionLabel string
+	ExcludeLabel          string
+	LabelRefreshInterval  time.Duration
+	OptionLabelPrefix     string
+	ImageOptions          []ImageOptionsConfig
+	StrictMode            string
+	ImageDigest           ImageDigestConfig
+	LatencyField          bool
+	MaxLineBytes          int
+	VersionField          bool
+	DebugSampleEvery      int
+	Selftest              bool
+
+	DedupeWindow         time.Duration
+	ForwardFirstLines    int
+	ForwardFirstLinesGap time.Duration
+	Sampling             SamplingConfig
+	QuietWindows         []QuietWindowConfig
+	RateLimit            RateLimitConfig
+	HostRateLimit        HostRateLimitConfig
+	TenantQuota          TenantQuotaConfig
+	Audit                AuditConfig
+	DropAlarm            DropAlarmConfig
+	Stats                StatsConfig
+	ContainerStatsMax    int
+	TagStatsTop          int
+	Admin                AdminConfig
+	MetricsAddress       string
+	Expvar               bool
+	ReadyTolerance       time.Duration
+	PprofAddress         string
+	Tracing              TracingConfig
+	MemoryLimit          int64
+	CPULimit             float64
+	DrainTimeout         time.Duration
+	HandleSignals        bool
+	Script               ScriptConfig
+	Wasm                 WasmConfig
+	Workers              WorkersConfig
+	Statsd               StatsdConfig
+
+	sources map[string]string
+}
+
+// WriterConfig configures the connection to one fluentd destination.
+type WriterConfig struct {
+	Address            string
+	BufferLimit        int
+	RetryWait          time.Duration
+	MaxRetries         int
+	Async              bool
+	SubSecondPrecision bool
+	RequestAck         bool
+	WriteTimeout       time.Duration
+	DryRun             bool
+}
+
+// DestinationConfig configures a named destination for label based routing.
+type DestinationConfig struct {
+	Name   string
+	Writer WriterConfig
+}
+
+// MirrorConfig configures the secondary destination records are copied to.
+type MirrorConfig struct {
+	Writer     WriterConfig
+	TagPattern string
+	QueueSize  int
+
+	// QueueWarn are the utilization percentages of the queue that log a
+	// warning when crossed.
+	QueueWarn []int
+}
+
+// ImageDigestConfig configures filtering and enrichment by image digest.
+type ImageDigestConfig struct {
+	Allow []string
+	Deny  []string
+	Field bool
+}
+
+// SamplingConfig configures 1-in-N sampling.
+type SamplingConfig struct {
+	Rate  int
+	Label string
+}
+
+// RateLimitConfig configures the per-container token bucket.
+type RateLimitConfig struct {
+	Lines          int
+	Burst          int
+	ReportInterval time.Duration
+}
+
+// HostRateLimitConfig configures the host-wide ceilings.
+type HostRateLimitConfig struct {
+	Lines int
+	Bytes int
+}
+
+// TenantQuotaConfig configures per-tenant daily byte quotas.
+type TenantQuotaConfig struct {
+	Label          string
+	DailyBytes     int64
+	Quotas         map[string]int64
+	Action         string
+	SampleRate     int
+	ReportInterval time.Duration
+}
+
+// AuditConfig configures the periodic drop audit record.
+type AuditConfig struct {
+	Enabled  bool
+	Interval time.Duration
+}
+
+// LoadConfig resolves the configuration of an adapter for the given address
+// and route options. Every invalid setting is reported in the returned error,
+// not only the first one.
+func LoadConfig(address string, options map[string]string) (*Config, error) {
+	route := canonicalOptions(options)
+	l := &configLoader{settings: &settings{route: route, envPrefix: route["env_prefix"]}}
+	config := l.load(address)
+	if err := l.err(); err != nil {
+		return nil, err
+	}
+	config.sources = l.sources
+	return config, nil
+}
+
+// load resolves every setting, collecting invalid ones in the loader.
+func (l *configLoader) load(address string) *Config {
+	config := &Config{EnvPrefix: l.settings.envPrefix}
+
+	// The config file, with the selected profile, provides defaults for all
+	// other settings
+	config.ConfigFile = l.string("config_file", "FLUENTD_CONFIG_FILE", "")
+	config.Profile = l.string("profile", "FLUENTD_PROFILE", "")
+	bundled, isBundled := bundledProfiles[config.Profile]
+	l.settings.profile = bundled
+	if config.ConfigFile != "" {
+		file, err := loadConfigFile(config.ConfigFile)
+		if err != nil {
+			l.errs = append(l.errs, err.Error())
+			file = &fileConfig{}
+		}
+		fileOptions, fileQuietWindows, err := file.withProfile(config.Profile)
+		if err != nil && !isBundled {
+			l.invalid("profile", "FLUENTD_PROFILE", config.Profile, "not defined in the config file")
+		}
+		l.settings.file = fileOptions
+		config.QuietWindows = fileQuietWindows
+		if err := (&quietWindows{}).update(fileQuietWindows); err != nil {
+			l.errs = append(l.errs, err.Error())
+		}
+		config.ImageOptions = file.Images
+		if err := validateImageOptions(file.Images); err != nil {
+			l.errs = append(l.errs, err.Error())
+		}
+	} else if config.Profile != "" && !isBundled {
+		l.invalid("profile", "FLUENTD_PROFILE", config.Profile,
+			"must be "+bundledProfileNames()+" or defined in FLUENTD_CONFIG_FILE")
+	}
+	config.ConfigReloadInterval = l.seconds("config_reload_interval", "FLUENTD_CONFIG_RELOAD_INTERVAL",
+		defaultConfigReloadInterval)
+
+	config.TagPrefix = expandEnv(l.string("tag_prefix", "TAG_PREFIX", "docker"))
+	config.TagSuffixLabel = l.string("tag_suffix_label", "TAG_SUFFIX_LABEL", "")
+
+	config.ConnectionMaxRetries = l.int("connection_max_retries", "CONNECTION_MAX_RETRIES", 10, 0)
+	config.ConnectionRetryWait = l.seconds("connection_retry_wait", "CONNECTION_RETRY_WAIT", 1)
+
+	config.Writer = l.writer(address)
+	config.Destinations = l.destinations()
+	config.RouteLabel = l.string("route_label", "FLUENTD_ROUTE_LABEL", "")
+	config.Routes = l.routes(config.Destinations)
+	config.DestinationQueueSize = l.int("destination_queue_size", "FLUENTD_DESTINATION_QUEUE_SIZE",
+		defaultDestinationQueueSize, 0)
+	config.QueueWarn = l.percentages("queue_warn", "FLUENTD_QUEUE_WARN", defaultQueueWarn)
+	config.Mirror = l.mirror()
+	l.sizeBuffers(config)
+
+	config.FilterHealthchecks = l.bool("filter_healthchecks", "FILTER_HEALTHCHECKS", false)
+	config.StreamFilter = l.oneOf("stream_filter", "STREAM_FILTER", "all", "all", "stdout", "stderr")
+	config.StreamFilterLabel = l.string("stream_filter_label", "STREAM_FILTER_LABEL", defaultStreamFilterLabel)
+	config.FilterExpression = l.string("filter_expression", "FILTER_EXPRESSION", "")
+	config.FilterExpressionLabel = l.string("filter_expression_label", "FILTER_EXPRESSION_LABEL",
+		defaultFilterExpressionLabel)
+	config.ExcludeLabel = l.string("exclude_label", "EXCLUDE_LABEL", defaultExcludeLabel)
+	config.LabelRefreshInterval = l.seconds("label_refresh_interval", "LABEL_REFRESH_INTERVAL",
+		defaultLabelRefreshInterval)
+	config.OptionLabelPrefix = l.string("option_label_prefix", "OPTION_LABEL_PREFIX", defaultOptionLabelPrefix)
+	config.StrictMode = l.oneOf("strict_mode", "STRICT_MODE", strictOff,
+		strictOff, strictAnnotate, strictDrop, strictFail)
+	config.ImageDigest = ImageDigestConfig{
+		Allow: l.list("image_digest_allow", "IMAGE_DIGEST_ALLOW"),
+		Deny:  l.list("image_digest_deny", "IMAGE_DIGEST_DENY"),
+		Field: l.bool("image_digest_field", "IMAGE_DIGEST_FIELD", false),
+	}
+	config.LatencyField = l.bool("latency_field", "LATENCY_FIELD", false)
+	config.MaxLineBytes = l.int("max_line_bytes", "MAX_LINE_BYTES", 0, 0)
+	config.VersionField = l.bool("version_field", "VERSION_FIELD", false)
+	config.DebugSampleEvery = l.int("debug_sample_every", "DEBUG_SAMPLE_EVERY", 0, 0)
+	config.Selftest = l.bool("selftest", "SELFTEST", false)
+
+	config.DedupeWindow = l.seconds("dedupe_window", "DEDUPE_WINDOW", defaultDedupeWindow)
+	config.ForwardFirstLines = l.int("forward_first_lines", "FORWARD_FIRST_LINES", defaultForwardFirstLines, 0)
+	config.ForwardFirstLinesGap = l.seconds("forward_first_lines_gap", "FORWARD_FIRST_LINES_GAP",
+		defaultForwardFirstLinesGap)
+	if config.ForwardFirstLinesGap <= 0 {
+		// Every line would start a stream, bypassing sampling and rate limits
+		l.invalid("forward_first_lines_gap", "FORWARD_FIRST_LINES_GAP", config.ForwardFirstLinesGap.String(),
+			"must be positive")
+	}
+	config.Sampling = SamplingConfig{
+		Rate:  l.int("sample_rate", "SAMPLE_RATE", defaultSampleRate, 1),
+		Label: l.string("sample_rate_label", "SAMPLE_RATE_LABEL", defaultSampleRateLabel),
+	}
+	lines := l.int("rate_limit_lines", "RATE_LIMIT_LINES", defaultRateLimitLines, 0)
+	config.RateLimit = RateLimitConfig{
+		Lines: lines,
+		Burst: l.int("rate_limit_burst", "RATE_LIMIT_BURST", lines, 0),
+		ReportInterval: l.seconds("rate_limit_report_interval", "RATE_LIMIT_REPORT_INTERVAL",
+			defaultRateLimitReportInterval),
+	}
+	config.HostRateLimit = HostRateLimitConfig{
+		Lines: l.int("host_rate_limit_lines", "HOST_RATE_LIMIT_LINES", 0, 0),
+		Bytes: l.int("host_rate_limit_bytes", "HOST_RATE_LIMIT_BYTES", 0, 0),
+	}
+	config.TenantQuota = TenantQuotaConfig{
+		Label:      l.string("tenant_label", "TENANT_LABEL", ""),
+		DailyBytes: l.int64("tenant_daily_quota_bytes", "TENANT_DAILY_QUOTA_BYTES", 0),
+		Quotas:     l.quotas("tenant_quotas", "TENANT_QUOTAS"),
+		Action:     l.oneOf("tenant_quota_action", "TENANT_QUOTA_ACTION", "drop", "drop", "sample"),
+		SampleRate: l.int("tenant_quota_sample_rate", "TENANT_QUOTA_SAMPLE_RATE",
+			defaultTenantQuotaSampleRate, 1),
+		ReportInterval: l.seconds("tenant_quota_report_interval", "TENANT_QUOTA_REPORT_INTERVAL",
+			defaultTenantQuotaReportInterval),
+	}
+	config.Audit = AuditConfig{
+		Enabled:  l.bool("audit_drops", "AUDIT_DROPS", false),
+		Interval: l.seconds("audit_interval", "AUDIT_INTERVAL", defaultAuditInterval),
+	}
+	config.DropAlarm = DropAlarmConfig{
+		Webhook:   l.string("drop_alarm_webhook", "DROP_ALARM_WEBHOOK", ""),
+		Threshold: l.int64("drop_alarm_threshold", "DROP_ALARM_THRESHOLD", defaultDropAlarmThreshold),
+		Window:    l.seconds("drop_alarm_window", "DROP_ALARM_WINDOW", defaultDropAlarmWindow),
+		Reasons:   l.list("drop_alarm_reasons", "DROP_ALARM_REASONS"),
+	}
+	config.Stats = StatsConfig{
+		Interval: l.seconds("stats_interval", "STATS_INTERVAL", 0),
+		Tag:      l.string("stats_tag", "STATS_TAG", ""),
+	}
+	config.ContainerStatsMax = l.int("container_stats_max", "CONTAINER_STATS_MAX", defaultContainerStatsMax, 0)
+	config.TagStatsTop = l.int("tag_stats_top", "TAG_STATS_TOP", defaultTagStatsTop, 0)
+	config.Admin = AdminConfig{
+		Address: l.string("admin_address", "ADMIN_ADDRESS", ""),
+		Token:   l.string("admin_token", "ADMIN_TOKEN", ""),
+	}
+	if config.Admin.Address != "" && config.Admin.Token == "" {
+		l.invalid("admin_token", "ADMIN_TOKEN", "", "required when ADMIN_ADDRESS is set")
+	}
+	config.MetricsAddress = l.string("metrics_address", "METRICS_ADDRESS", "")
+	config.Expvar = l.bool("expvar", "EXPVAR", false)
+	config.ReadyTolerance = l.seconds("ready_tolerance", "READY_TOLERANCE", defaultReadyTolerance)
+	config.PprofAddress = l.string("pprof_address", "PPROF_ADDRESS", "")
+	config.Statsd = StatsdConfig{
+		Address:   l.string("statsd_address", "STATSD_ADDRESS", ""),
+		Prefix:    l.string("statsd_prefix", "STATSD_PREFIX", defaultStatsdPrefix),
+		Interval:  l.seconds("statsd_interval", "STATSD_INTERVAL", defaultStatsdInterval),
+		DogStatsD: l.bool("statsd_dogstatsd", "STATSD_DOGSTATSD", false),
+	}
+	config.Workers = WorkersConfig{
+		Count:     l.int("workers", "WORKERS", 1, 1),
+		QueueSize: l.int("worker_queue_size", "WORKER_QUEUE_SIZE", defaultWorkerQueueSize, 1),
+
+		StreamBuffer: l.int("stream_buffer_size", "STREAM_BUFFER_SIZE", 0, 0),
+	}
+	config.CPULimit = l.float("cpu_limit", "CPU_LIMIT", 0)
+	config.DrainTimeout = l.seconds("drain_timeout", "DRAIN_TIMEOUT", defaultDrainTimeout)
+	config.HandleSignals = l.bool("handle_signals", "HANDLE_SIGNALS", false)
+	config.Script = ScriptConfig{
+		File:     l.string("script_file", "SCRIPT_FILE", ""),
+		MaxSteps: l.int("script_max_steps", "SCRIPT_MAX_STEPS", defaultScriptMaxSteps, 1),
+	}
+	config.Wasm = WasmConfig{
+		File:    l.string("wasm_file", "WASM_FILE", ""),
+		Timeout: l.duration("wasm_timeout", "WASM_TIMEOUT", defaultWasmTimeout, time.Millisecond),
+	}
+	config.Tracing = TracingConfig{
+		Endpoint:   l.string("trace_endpoint", "TRACE_ENDPOINT", ""),
+		Insecure:   l.bool("trace_insecure", "TRACE_INSECURE", false),
+		SampleRate: l.int("trace_sample_rate", "TRACE_SAMPLE_RATE", defaultTraceSampleRate, 1),
+	}
+	return config
+}
+
+// configLoader parses settings into typed values. Invalid settings are
+// collected, with the variable name, instead of failing on the first one. A
+// static loader only describes the settings: every setting has its default
+// and neither the environment nor any file is read.
+type configLoader struct {
+	settings *settings
+	static   bool
+	errs     []string
+	schema   []OptionSchema
+	sources  map[string]string
+}
+
+// invalid records an invalid setting.
+func (l *configLoader) invalid(key, envKey, value, reason string) {
+	l.errs = append(l.errs, fmt.Sprintf("%s=%q (option %s): %s", envKey, value, key, reason))
+}
+
+// err returns an error listing all invalid settings, or nil.
+func (l *configLoader) err() error {
+	if len(l.errs) == 0 {
+		return nil
+	}
+	return errors.New("Invalid fluentd adapter configuration:\n\t" + strings.Join(l.errs, "\n\t"))
+}
+
+// get resolves a setting and records its source, recording an unreadable
+// _FILE variant as invalid.
+func (l *configLoader) get(key, envKey, fallback string) string {
+	if l.static {
+		return fallback
+	}
+	value, source, err := l.settings.get(key, envKey, fallback)
+	if err != nil {
+		l.invalid(key, envKey+fileSuffix, getenv(envKey+fileSuffix, ""), err.Error())
+		value, source = fallback, sourceDefault
+	}
+	if l.sources == nil {
+		l.sources = make(map[string]string)
+	}
+	l.sources[key] = source
+	return value
+}
+
+func (l *configLoader) string(key, envKey, fallback string) string {
+	l.describe(key, envKey, "string", fallback)
+	return l.get(key, envKey, fallback)
+}
+
+func (l *configLoader) oneOf(key, envKey, fallback string, allowed ...string) string {
+	l.describe(key, envKey, strings.Join(allowed, "|"), fallback)
+	value := l.get(key, envKey, fallback)
+	for _, candidate := range allowed {
+		if value == candidate {
+			return value
+		}
+	}
+	l.invalid(key, envKey, value, "must be one of "+strings.Join(allowed, ", "))
+	return fallback
+}
+
+func (l *configLoader) int(key, envKey string, fallback, min int) int {
+	l.describe(key, envKey, "integer", strconv.Itoa(fallback))
+	value := l.get(key, envKey, strconv.Itoa(fallback))
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		l.invalid(key, envKey, value, "must be an integer")
+		return fallback
+	}
+	if n < min {
+		l.invalid(key, envKey, value, fmt.Sprintf("must be at least %d", min))
+		return fallback
+	}
+	return n
+}
+
+// float parses a non-negative number such as 1.5.
+func (l *configLoader) float(key, envKey string, fallback float64) float64 {
+	l.describe(key, envKey, "number", strconv.FormatFloat(fallback, 'g', -1, 64))
+	value := l.get(key, envKey, strconv.FormatFloat(fallback, 'g', -1, 64))
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		l.invalid(key, envKey, value, "must be a non-negative number")
+		return fallback
+	}
+	return f
+}
+
+func (l *configLoader) int64(key, envKey string, fallback int64) int64 {
+	l.describe(key, envKey, "integer", strconv.FormatInt(fallback, 10))
+	value := l.get(key, envKey, strconv.FormatInt(fallback, 10))
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		l.invalid(key, envKey, value, "must be a non-negative integer")
+		return fallback
+	}
+	return n
+}
+
+func (l *configLoader) bool(key, envKey string, fallback bool) bool {
+	l.describe(key, envKey, "boolean", strconv.FormatBool(fallback))
+	value := l.get(key, envKey, strconv.FormatBool(fallback))
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.invalid(key, envKey, value, "must be true or false")
+		return fallback
+	}
+	return b
+}
+
+// seconds parses a non-negative duration whose plain integers, as accepted
+// before duration strings were supported, are seconds.
+func (l *configLoader) seconds(key, envKey string, fallback int) time.Duration {
+	return l.duration(key, envKey, time.Duration(fallback)*time.Second, time.Second)
+}
+
+// duration parses a non-negative Go duration such as "500ms" or "1m30s". A
+// plain integer is taken in the given unit.
+func (l *configLoader) duration(key, envKey string, fallback, unit time.Duration) time.Duration {
+	l.describe(key, envKey, "duration", fallback.String())
+	value := l.get(key, envKey, "")
+	if value == "" {
+		return fallback
+	}
+	d, err := parseDuration(value, unit)
+	if err != nil {
+		l.invalid(key, envKey, value, "must be a duration such as 1m30s or an integer")
+		return fallback
+	}
+	if d < 0 {
+		l.invalid(key, envKey, value, "must not be negative")
+		return fallback
+	}
+	return d
+}
+
+// parseDuration parses a Go duration, or an integer in the given unit.
+func parseDuration(value string, unit time.Duration) (time.Duration, error) {
+	if n, err := strconv.Atoi(value); err == nil {
+		return time.Duration(n) * unit, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func (l *configLoader) list(key, envKey string) []string {
+	l.describe(key, envKey, "list", "")
+	return splitList(l.get(key, envKey, ""))
+}
+
+// percentages parses a list of percentages such as "50,80,95".
+func (l *configLoader) percentages(key, envKey, fallback string) []int {
+	l.describe(key, envKey, "list", fallback)
+	var percentages []int
+	for _, entry := range splitList(l.get(key, envKey, fallback)) {
+		percentage, err := strconv.Atoi(entry)
+		if err != nil || percentage < 1 || percentage > 100 {
+			l.invalid(key, envKey, entry, "must be a percentage between 1 and 100")
+			continue
+		}
+		percentages = append(percentages, percentage)
+	}
+	sort.Ints(percentages)
+	return percentages
+}
+
+// writer parses the connection settings for a fluentd address.
+func (l *configLoader) writer(address string) WriterConfig {
+	if _, port, err := net.SplitHostPort(address); err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("Invalid fluentd-address %s: %v", address, err))
+	} else if _, err := strconv.Atoi(port); err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("Invalid fluentd-address %s: port must be numeric", address))
+	}
+
+	return WriterConfig{
+		Address:            address,
+		BufferLimit:        l.int("buffer_limit", "FLUENTD_BUFFER_LIMIT", defaultBufferLimit, 1),
+		RetryWait:          l.duration("retry_wait", "FLUENTD_RETRY_WAIT", defaultRetryWait, time.Millisecond),
+		MaxRetries:         l.int("max_retries", "FLUENTD_MAX_RETRIES", defaultMaxRetries, 0),
+		Async:              l.bool("async", "FLUENTD_ASYNC_CONNECT", false),
+		SubSecondPrecision: l.bool("subsecond_precision", "FLUENTD_SUBSECOND_PRECISION", false),
+		RequestAck:         l.bool("request_ack", "FLUENTD_REQUEST_ACK", false),
+		WriteTimeout:       l.seconds("write_timeout", "FLUENTD_WRITE_TIMEOUT", defaultWriteTimeout),
+		DryRun:             l.bool("dry_run", "FLUENTD_DRY_RUN", false),
+	}
+}
+
+// subWriter parses the connection settings of a secondary destination given
+// as "host:port?option=value", whose options, or else the given defaults,
+// override the route options.
+func (l *configLoader) subWriter(name, spec string, defaults map[string]string) WriterConfig {
+	address, options, err := parseAddress(spec)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("Invalid options for fluentd %s: %v", name, err))
+	}
+	if options == nil {
+		options = make(map[string]string)
+	}
+	for key, value := range defaults {
+		if _, found := options[key]; !found {
+			options[key] = value
+		}
+	}
+	sub := &configLoader{settings: l.settings.override(options)}
+	config := sub.writer(address)
+	for _, err := range sub.errs {
+		l.errs = append(l.errs, name+": "+err)
+	}
+	return config
+}
+
+// destinations parses FLUENTD_DESTINATIONS, a list such as
+// "audit=10.0.0.5:24224?async=true,staging=10.0.0.6:24224".
+func (l *configLoader) destinations() []DestinationConfig {
+	var destinations []DestinationConfig
+	for _, entry := range l.list("destinations", "FLUENTD_DESTINATIONS") {
+		name, spec, found := strings.Cut(entry, "=")
+		if !found || name == "" {
+			l.invalid("destinations", "FLUENTD_DESTINATIONS", entry, "must be name=host:port")
+			continue
+		}
+		destinations = append(destinations, DestinationConfig{
+			Name:   name,
+			Writer: l.subWriter("destination "+name, spec, nil),
+		})
+	}
+	return destinations
+}
+
+// routes parses FLUENTD_ROUTES, mapping label values to destination names
+// such as "payments=audit".
+func (l *configLoader) routes(destinations []DestinationConfig) map[string]string {
+	routes := make(map[string]string)
+	for _, entry := range l.list("routes", "FLUENTD_ROUTES") {
+		value, name, found := strings.Cut(entry, "=")
+		if !found {
+			l.invalid("routes", "FLUENTD_ROUTES", entry, "must be value=destination")
+			continue
+		}
+		known := false
+		for _, destination := range destinations {
+			known = known || destination.Name == name
+		}
+		if !known {
+			l.invalid("routes", "FLUENTD_ROUTES", entry, "unknown destination "+name)
+			continue
+		}
+		routes[value] = name
+	}
+	return routes
+}
+
+// mirror parses the mirror settings, returning nil when no mirror is configured.
+func (l *configLoader) mirror() *MirrorConfig {
+	spec := l.string("mirror_address", "FLUENTD_MIRROR_ADDRESS", "")
+	tagPattern := expandEnv(l.string("mirror_tag_pattern", "FLUENTD_MIRROR_TAG_PATTERN", ""))
+	queueSize := l.int("mirror_queue_size", "FLUENTD_MIRROR_QUEUE_SIZE", defaultMirrorQueueSize, 0)
+	queueWarn := l.percentages("mirror_queue_warn", "FLUENTD_MIRROR_QUEUE_WARN", defaultQueueWarn)
+	if spec == "" {
+		return nil
+	}
+	if _, err := regexp.Compile(tagPattern); err != nil {
+		l.invalid("mirror_tag_pattern", "FLUENTD_MIRROR_TAG_PATTERN", tagPattern, err.Error())
+	}
+	return &MirrorConfig{
+		// Connect in the background so an unreachable mirror can't block startup
+		Writer:     l.subWriter("mirror", spec, map[string]string{"async": "true"}),
+		TagPattern: tagPattern,
+		QueueSize:  queueSize,
+		QueueWarn:  queueWarn,
+	}
+}
+
+// quotas parses a list of tenant quotas such as "team-a=1073741824".
+func (l *configLoader) quotas(key, envKey string) map[string]int64 {
+	quotas := make(map[string]int64)
+	for _, entry := range l.list(key, envKey) {
+		tenant, value, found := strings.Cut(entry, "=")
+		quota, err := strconv.ParseInt(value, 10, 64)
+		if !found || err != nil || quota < 0 {
+			l.invalid(key, envKey, entry, "must be tenant=bytes")
+			continue
+		}
+		quotas[tenant] = quota
+	}
+	return quotas
+}
+
+// Sources of resolved settings, see Config.Source.
+const (
+	sourceRoute   = "route option"
+	sourceEnv     = "environment"
+	sourceFile    = "config file"
+	sourceProfile = "bundled profile"
+	sourceDefault = "default"
+)
+
+// logspoutDefaults enables the process-wide features that adapters created by
+// logspout have by default, but programs embedding the package opt in to:
+// signal handling and expvar counters.
+func (c *Config) logspoutDefaults() {
+	if c.Source("handle_signals") == sourceDefault {
+		c.HandleSignals = true
+	}
+	if c.Source("expvar") == sourceDefault {
+		c.Expvar = true
+	}
+}
+
+// Source returns where the setting with the given option name was resolved
+// from: the route option, the environment variable, the config file, the
+// bundled profile or the default.
+func (c *Config) Source(option string) string {
+	return c.sources[option]
+}
+
+// Sources returns the source of every resolved setting by option name.
+func (c *Config) Sources() map[string]string {
+	sources := make(map[string]string, len(c.sources))
+	for option, source := range c.sources {
+		sources[option] = source
+	}
+	return sources
+}
+
+// settings resolves adapter settings by option name. Route options take
+// precedence over environment variables, which take precedence over values
+// from the config file, which take precedence over the defaults of a bundled
+// profile and then the built-in defaults. Container labels
+// (see containerOptions) rank between route options and environment
+// variables: they override every source but a route option. Any environment
+// variable may instead be given as <NAME>_FILE holding the path of a file
+// with the value, so credentials can come from Docker secrets rather than the
+// plain environment.
+//
+// Routes sharing one logspout share its environment. The env_prefix route
+// option gives a route its own variables: with env_prefix=PROD_, PROD_TAG_PREFIX
+// takes precedence over TAG_PREFIX for that route only.
+type settings struct {
+	route     map[string]string
+	envPrefix string
+	file      map[string]string
+	profile   map[string]string
+}
+
+// get returns the setting with the given option name and environment
+// variable, or fallback when it is not set anywhere.
+func (s *settings) get(key, envKey, fallback string) (string, string, error) {
+	if value := s.route[key]; len(value) > 0 {
+		return value, sourceRoute, nil
+	}
+	if value := getenv(s.envPrefix+envKey, ""); len(value) > 0 && s.envPrefix != "" {
+		return value, sourceEnv + " " + s.envPrefix + envKey, nil
+	}
+	if value := getenv(envKey, ""); len(value) > 0 {
+		return value, sourceEnv + " " + envKey, nil
+	}
+	if alias := envAliases[envKey]; len(getenv(alias, "")) > 0 {
+		return getenv(alias, ""), sourceEnv + " " + alias, nil
+	}
+	if path := getenv(envKey+fileSuffix, ""); len(path) > 0 {
+		value, err := readSecretFile(path)
+		return value, sourceEnv + " " + envKey + fileSuffix, err
+	}
+	if value := s.file[key]; len(value) > 0 {
+		return value, sourceFile, nil
+	}
+	if value := s.profile[key]; len(value) > 0 {
+		return value, sourceProfile, nil
+	}
+	return fallback, sourceDefault, nil
+}
+
+// readSecretFile reads a setting from a file, such as a Docker secret mounted
+// under /run/secrets. A single trailing newline is not part of the value.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
+
+// override returns settings where the given options take precedence over the
+// route options, e.g. for the options of a secondary destination.
+func (s *settings) override(options map[string]string) *settings {
+	route := make(map[string]string, len(s.route)+len(options))
+	for key, value := range s.route {
+		route[key] = value
+	}
+	for key, value := range canonicalOptions(options) {
+		route[key] = value
+	}
+	return &settings{route: route, envPrefix: s.envPrefix, file: s.file, profile: s.profile}
+}
@@ -0,0 +1,310 @@
+package fluentd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		options map[string]string
+		env     map[string]string
+		check   func(*Config) interface{}
+		want    interface{}
+	}{
+		{
+			name: "defaults",
+			check: func(c *Config) interface{} {
+				return []interface{}{c.TagPrefix, c.Writer.RetryWait, c.Writer.Async}
+			},
+			want: []interface{}{"docker", defaultRetryWait, false},
+		},
+		{
+			name:    "route option",
+			options: map[string]string{"tag_prefix": "app"},
+			env:     map[string]string{"TAG_PREFIX": "env"},
+			check:   func(c *Config) interface{} { return c.TagPrefix },
+			want:    "app",
+		},
+		{
+			name:  "environment",
+			env:   map[string]string{"TAG_PREFIX": "env"},
+			check: func(c *Config) interface{} { return c.TagPrefix },
+			want:  "env",
+		},
+		{
+			name:    "env prefix",
+			options: map[string]string{"env_prefix": "PROD_"},
+			env:     map[string]string{"TAG_PREFIX": "env", "PROD_TAG_PREFIX": "prod"},
+			check:   func(c *Config) interface{} { return c.TagPrefix },
+			want:    "prod",
+		},
+		{
+			name:    "log driver alias",
+			options: map[string]string{"fluentd-async": "true"},
+			check:   func(c *Config) interface{} { return c.Writer.Async },
+			want:    true,
+		},
+		{
+			name:  "environment alias",
+			env:   map[string]string{"FLUENTD_ASYNC": "true"},
+			check: func(c *Config) interface{} { return c.Writer.Async },
+			want:  true,
+		},
+		{
+			name:    "retry wait in milliseconds",
+			options: map[string]string{"retry_wait": "250"},
+			check:   func(c *Config) interface{} { return c.Writer.RetryWait },
+			want:    250 * time.Millisecond,
+		},
+		{
+			name:    "duration string",
+			options: map[string]string{"drain_timeout": "1m30s"},
+			check:   func(c *Config) interface{} { return c.DrainTimeout },
+			want:    90 * time.Second,
+		},
+		{
+			name:    "zero duration",
+			options: map[string]string{"label_refresh_interval": "0"},
+			check:   func(c *Config) interface{} { return c.LabelRefreshInterval },
+			want:    time.Duration(0),
+		},
+		{
+			name:    "minimum",
+			options: map[string]string{"workers": "1", "max_retries": "0"},
+			check:   func(c *Config) interface{} { return []int{c.Workers.Count, c.Writer.MaxRetries} },
+			want:    []int{1, 0},
+		},
+		{
+			name:    "sorted percentages",
+			options: map[string]string{"queue_warn": "95,1,100"},
+			check:   func(c *Config) interface{} { return c.QueueWarn },
+			want:    []int{1, 95, 100},
+		},
+		{
+			name:    "explicit buffer limit",
+			options: map[string]string{"buffer_limit": "10", "buffer_memory_percent": "50"},
+			check:   func(c *Config) interface{} { return c.Writer.BufferLimit },
+			want:    10,
+		},
+		{
+			name:  "no mirror",
+			check: func(c *Config) interface{} { return c.Mirror == nil },
+			want:  true,
+		},
+		{
+			name:    "mirror connects in the background",
+			options: map[string]string{"mirror_address": "10.0.0.7:24224"},
+			check: func(c *Config) interface{} {
+				return []interface{}{c.Mirror.Writer.Address, c.Mirror.Writer.Async}
+			},
+			want: []interface{}{"10.0.0.7:24224", true},
+		},
+		{
+			name: "destinations and routes",
+			options: map[string]string{
+				"destinations": "audit=10.0.0.5:24224?request_ack=true",
+				"routes":       "payments=audit",
+			},
+			check: func(c *Config) interface{} {
+				return []interface{}{c.Destinations[0].Name, c.Destinations[0].Writer.RequestAck, c.Routes}
+			},
+			want: []interface{}{"audit", true, map[string]string{"payments": "audit"}},
+		},
+		{
+			name:    "IPv6 address",
+			address: "[::1]:24224",
+			check:   func(c *Config) interface{} { return c.Writer.Address },
+			want:    "[::1]:24224",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			address := test.address
+			if address == "" {
+				address = "localhost:24224"
+			}
+			config, err := LoadConfig(address, test.options)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if got := test.check(config); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		options map[string]string
+		env     map[string]string
+		want    []string
+	}{
+		{
+			name:    "address without port",
+			address: "localhost",
+			want:    []string{"Invalid fluentd-address localhost"},
+		},
+		{
+			name:    "port not numeric",
+			address: "localhost:fluentd",
+			want:    []string{"port must be numeric"},
+		},
+		{
+			name:    "not an integer",
+			options: map[string]string{"workers": "two"},
+			want:    []string{`WORKERS="two" (option workers): must be an integer`},
+		},
+		{
+			name:    "below minimum",
+			options: map[string]string{"workers": "0"},
+			want:    []string{`WORKERS="0" (option workers): must be at least 1`},
+		},
+		{
+			name:    "negative duration",
+			options: map[string]string{"drain_timeout": "-1s"},
+			want:    []string{"must not be negative"},
+		},
+		{
+			name:    "not a duration",
+			options: map[string]string{"drain_timeout": "soon"},
+			want:    []string{"must be a duration such as 1m30s or an integer"},
+		},
+		{
+			name:    "first lines gap",
+			options: map[string]string{"forward_first_lines_gap": "0"},
+			want:    []string{`FORWARD_FIRST_LINES_GAP="0s" (option forward_first_lines_gap): must be positive`},
+		},
+		{
+			name: "not a boolean",
+			env:  map[string]string{"FLUENTD_REQUEST_ACK": "maybe"},
+			want: []string{`FLUENTD_REQUEST_ACK="maybe" (option request_ack): must be true or false`},
+		},
+		{
+			name:    "not allowed",
+			options: map[string]string{"stream_filter": "stdin"},
+			want:    []string{"must be one of all, stdout, stderr"},
+		},
+		{
+			name:    "percentage out of range",
+			options: map[string]string{"queue_warn": "0,101"},
+			want:    []string{`FLUENTD_QUEUE_WARN="0"`, `FLUENTD_QUEUE_WARN="101"`},
+		},
+		{
+			name:    "admin without token",
+			options: map[string]string{"admin_address": "127.0.0.1:9000"},
+			want:    []string{"required when ADMIN_ADDRESS is set"},
+		},
+		{
+			name:    "unknown destination",
+			options: map[string]string{"routes": "payments=audit"},
+			want:    []string{"unknown destination audit"},
+		},
+		{
+			name:    "invalid destination option",
+			options: map[string]string{"destinations": "audit=10.0.0.5:24224?buffer_limit=0"},
+			want:    []string{"destination audit: FLUENTD_BUFFER_LIMIT"},
+		},
+		{
+			name:    "mirror tag pattern",
+			options: map[string]string{"mirror_address": "10.0.0.7:24224", "mirror_tag_pattern": "("},
+			want:    []string{"FLUENTD_MIRROR_TAG_PATTERN"},
+		},
+		{
+			name:    "unknown profile",
+			options: map[string]string{"profile": "nonexistent"},
+			want:    []string{`FLUENTD_PROFILE="nonexistent"`},
+		},
+		{
+			name: "unreadable secret file",
+			env:  map[string]string{"ADMIN_TOKEN_FILE": "/nonexistent/token"},
+			want: []string{`ADMIN_TOKEN_FILE="/nonexistent/token"`},
+		},
+		{
+			name:    "every setting reported",
+			options: map[string]string{"workers": "0", "sample_rate": "0"},
+			want:    []string{"option workers", "option sample_rate"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			address := test.address
+			if address == "" {
+				address = "localhost:24224"
+			}
+			_, err := LoadConfig(address, test.options)
+			if err == nil {
+				t.Fatal("LoadConfig succeeded")
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("secret\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADMIN_TOKEN_FILE", path)
+
+	config, err := LoadConfig("localhost:24224", nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if config.Admin.Token != "secret" {
+		t.Errorf("token = %q, want %q", config.Admin.Token, "secret")
+	}
+	if source := config.Source("admin_token"); source != sourceEnv+" ADMIN_TOKEN_FILE" {
+		t.Errorf("source = %q", source)
+	}
+}
+
+func TestConfigSources(t *testing.T) {
+	t.Setenv("TAG_PREFIX", "env")
+	config, err := LoadConfig("localhost:24224", map[string]string{"workers": "2"})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	want := map[string]string{
+		"workers":        sourceRoute,
+		"tag_prefix":     sourceEnv + " TAG_PREFIX",
+		"drain_timeout":  sourceDefault,
+		"handle_signals": sourceDefault,
+	}
+	for option, source := range want {
+		if got := config.Source(option); got != source {
+			t.Errorf("Source(%q) = %q, want %q", option, got, source)
+		}
+	}
+
+	// Only unset settings get logspout's defaults
+	config.logspoutDefaults()
+	if !config.HandleSignals || !config.Expvar {
+		t.Error("logspout defaults not applied")
+	}
+	config, _ = LoadConfig("localhost:24224", map[string]string{"handle_signals": "false"})
+	config.logspoutDefaults()
+	if config.HandleSignals {
+		t.Error("explicit handle_signals=false overridden")
+	}
+}
@@ -0,0 +1,102 @@
+package fluentd
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"regexp"
+	"time"
+)
+
+// secretName matches the names of settings whose values must never be logged.
+var secretName = regexp.MustCompile(`(?i)(password|passwd|secret|token|shared_?key|credential)`)
+
+// urlName matches the names of settings holding URLs, such as webhooks, which
+// commonly carry tokens in their path.
+var urlName = regexp.MustCompile(`(?i)(webhook|url|endpoint)`)
+
+const redacted = "REDACTED"
+
+// String returns the configuration as a single line of JSON with secrets
+// redacted, suitable for logging.
+func (c *Config) String() string {
+	data, err := json.Marshal(redact(reflect.ValueOf(c).Elem()))
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// redact converts a configuration value into plain JSON-friendly values,
+// replacing the values of secret fields and map keys and rendering durations
+// as strings.
+func redact(v reflect.Value) interface{} {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redact(v.Elem())
+	case reflect.Struct:
+		fields := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			fields[field.Name] = redactNamed(field.Name, v.Field(i))
+		}
+		return fields
+	case reflect.Map:
+		entries := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			name := key.String()
+			entries[name] = redactNamed(name, v.MapIndex(key))
+		}
+		return entries
+	case reflect.String:
+		return redactURL(v.String(), false)
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = redact(v.Index(i))
+		}
+		return items
+	default:
+		return v.Interface()
+	}
+}
+
+func redactNamed(name string, v reflect.Value) interface{} {
+	if secretName.MatchString(name) && !v.IsZero() {
+		return redacted
+	}
+	if urlName.MatchString(name) && v.Kind() == reflect.String {
+		return redactURL(v.String(), true)
+	}
+	return redact(v)
+}
+
+// redactURL redacts the user info and query of a URL, and with path also its
+// path, keeping the scheme and host. Other values are returned unchanged.
+func redactURL(value string, path bool) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return value
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redacted
+	}
+	if path && u.Path != "" && u.Path != "/" {
+		u.Path, u.RawPath = "/"+redacted, ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
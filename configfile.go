@@ -0,0 +1,268 @@
+package fluentd
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the structure of the optional FLUENTD_CONFIG_FILE. Being YAML,
+// the file may also be written as JSON. Its values are defaults that
+// environment variables and route options override.
+type fileConfig struct {
+	Tags struct {
+		Prefix      string `yaml:"prefix"`
+		SuffixLabel string `yaml:"suffix_label"`
+	} `yaml:"tags"`
+
+	Destinations []destinationConfig `yaml:"destinations"`
+	Routes       struct {
+		Label string            `yaml:"label"`
+		Rules map[string]string `yaml:"rules"`
+	} `yaml:"routes"`
+	Mirror struct {
+		Address    string `yaml:"address"`
+		TagPattern string `yaml:"tag_pattern"`
+		QueueSize  int    `yaml:"queue_size"`
+	} `yaml:"mirror"`
+
+	Filters struct {
+		Healthchecks     *bool    `yaml:"healthchecks"`
+		Stream           string   `yaml:"stream"`
+		StreamLabel      string   `yaml:"stream_label"`
+		Expression       string   `yaml:"expression"`
+		ExpressionLabel  string   `yaml:"expression_label"`
+		ExcludeLabel     string   `yaml:"exclude_label"`
+		OptionPrefix     string   `yaml:"option_label_prefix"`
+		ImageDigestAllow []string `yaml:"image_digest_allow"`
+		ImageDigestDeny  []string `yaml:"image_digest_deny"`
+	} `yaml:"filters"`
+
+	Enrichment struct {
+		ImageDigest *bool `yaml:"image_digest"`
+	} `yaml:"enrichment"`
+
+	QuietWindows []QuietWindowConfig `yaml:"quiet_windows"`
+
+	// Images maps image name patterns to container options, see
+	// ImageOptionsConfig.
+	Images []ImageOptionsConfig `yaml:"images"`
+
+	// Options sets any other setting by its route option name.
+	Options map[string]string `yaml:"options"`
+
+	// Profiles are named sets of settings, selected with the profile route
+	// option or FLUENTD_PROFILE, that take precedence over the rest of the file.
+	Profiles map[string]*fileConfig `yaml:"profiles"`
+}
+
+// destinationConfig declares a named fluentd destination.
+type destinationConfig struct {
+	Name    string            `yaml:"name"`
+	Address string            `yaml:"address"`
+	Options map[string]string `yaml:"options"`
+}
+
+// options flattens the file into settings keyed by route option name.
+func (c *fileConfig) options() map[string]string {
+	options := make(map[string]string, len(c.Options))
+	for key, value := range canonicalOptions(c.Options) {
+		options[key] = value
+	}
+	set := func(key, value string) {
+		if len(value) > 0 {
+			options[key] = value
+		}
+	}
+	setBool := func(key string, value *bool) {
+		if value != nil {
+			options[key] = strconv.FormatBool(*value)
+		}
+	}
+
+	set("tag_prefix", c.Tags.Prefix)
+	set("tag_suffix_label", c.Tags.SuffixLabel)
+
+	destinations := make([]string, 0, len(c.Destinations))
+	for _, destination := range c.Destinations {
+		query := url.Values{}
+		for key, value := range destination.Options {
+			query.Set(key, value)
+		}
+		spec := destination.Name + "=" + destination.Address
+		if len(query) > 0 {
+			spec += "?" + query.Encode()
+		}
+		destinations = append(destinations, spec)
+	}
+	set("destinations", strings.Join(destinations, ","))
+	set("route_label", c.Routes.Label)
+	routes := make([]string, 0, len(c.Routes.Rules))
+	for value, destination := range c.Routes.Rules {
+		routes = append(routes, value+"="+destination)
+	}
+	sort.Strings(routes)
+	set("routes", strings.Join(routes, ","))
+
+	set("mirror_address", c.Mirror.Address)
+	set("mirror_tag_pattern", c.Mirror.TagPattern)
+	if c.Mirror.QueueSize > 0 {
+		set("mirror_queue_size", strconv.Itoa(c.Mirror.QueueSize))
+	}
+
+	setBool("filter_healthchecks", c.Filters.Healthchecks)
+	set("stream_filter", c.Filters.Stream)
+	set("stream_filter_label", c.Filters.StreamLabel)
+	set("filter_expression", c.Filters.Expression)
+	set("filter_expression_label", c.Filters.ExpressionLabel)
+	set("exclude_label", c.Filters.ExcludeLabel)
+	set("option_label_prefix", c.Filters.OptionPrefix)
+	set("image_digest_allow", strings.Join(c.Filters.ImageDigestAllow, ","))
+	set("image_digest_deny", strings.Join(c.Filters.ImageDigestDeny, ","))
+
+	setBool("image_digest_field", c.Enrichment.ImageDigest)
+	return options
+}
+
+// withProfile returns the file with the named profile applied on top of it.
+// When the profile is not defined, the file is returned as is together with
+// an error.
+func (c *fileConfig) withProfile(name string) (map[string]string, []QuietWindowConfig, error) {
+	options := c.options()
+	quietWindows := c.QuietWindows
+	if name == "" {
+		return options, quietWindows, nil
+	}
+
+	profile, found := c.Profiles[name]
+	if !found || profile == nil {
+		return options, quietWindows, errors.Errorf("profile %s is not defined in the config file", name)
+	}
+	for key, value := range profile.options() {
+		options[key] = value
+	}
+	if len(profile.QuietWindows) > 0 {
+		quietWindows = profile.QuietWindows
+	}
+	return options, quietWindows, nil
+}
+
+// loadConfigFile reads and parses the config file at path.
+func loadConfigFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read config file %s", path)
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse config file %s", path)
+	}
+	interpolate(&document)
+	config := &fileConfig{}
+	if err := document.Decode(config); err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse config file %s", path)
+	}
+	return config, nil
+}
+
+// envReference matches ${NAME} references to the environment of logspout.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// startupEnv is the environment of logspout when a reference was first
+// expanded, so a reload resolves references to the same values.
+var startupEnv struct {
+	once   sync.Once
+	values map[string]string
+}
+
+// expandEnv replaces ${NAME} in value with the environment variable NAME as
+// it was at startup. Unset variables expand to the empty string.
+func expandEnv(value string) string {
+	if !strings.Contains(value, "${") {
+		return value
+	}
+	startupEnv.once.Do(func() {
+		startupEnv.values = make(map[string]string)
+		for _, entry := range os.Environ() {
+			if name, value, ok := strings.Cut(entry, "="); ok {
+				startupEnv.values[name] = value
+			}
+		}
+	})
+	return envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		return startupEnv.values[envReference.FindStringSubmatch(reference)[1]]
+	})
+}
+
+// interpolate replaces ${NAME} in the values of a config file with the
+// environment variable NAME, e.g. tags: {prefix: "docker.${HOST_REGION}"}.
+// Keys are left as they are.
+func interpolate(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		node.Value = expandEnv(node.Value)
+		return
+	}
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		interpolate(child)
+	}
+}
+
+// watchConfigFile polls the config file for changes every interval, until ctx
+// is done, and calls apply with the new contents. Invalid changes are logged
+// and the previous configuration is kept.
+func watchConfigFile(ctx context.Context, path string, interval time.Duration,
+	apply func(*fileConfig) error) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to read config file %s", path)
+	}
+	modTime := info.ModTime()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				logError("Unable to read config file", "path", path, "error", err)
+				continue
+			}
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+
+			config, err := loadConfigFile(path)
+			if err == nil {
+				err = apply(config)
+			}
+			if err != nil {
+				logError("Config reload failed", "path", path, "error", err)
+				continue
+			}
+			logInfo("Reloaded config file", "path", path)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,139 @@
+package fluentd
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Connection events and the reasons not derived from a post failure, see
+// errorType for the others.
+const (
+	eventConnect       = "connect"
+	eventConnectFailed = "connect_failed"
+	eventDisconnect    = "disconnect"
+	eventReconnect     = "reconnect"
+	eventDNSChange     = "dns_change"
+
+	reasonDial  = "dial"
+	reasonPost  = "post"
+	reasonAdmin = "admin"
+)
+
+var connectionEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "connection_events_total",
+	Help:      "Connection events of the route's fluentd, by event and reason.",
+}, []string{"route", "event", "reason"})
+
+func init() {
+	prometheus.MustRegister(connectionEventsTotal)
+}
+
+// connectionEvents logs an audit trail of the connection to a route's fluentd:
+// connects, disconnects and reconnects with their cause, and changes of the
+// addresses its host name resolves to. Disconnects are detected from failed
+// posts and reconnects from the first successful post after them, as the
+// fluent logger reconnects internally.
+type connectionEvents struct {
+	route string
+	host  string
+
+	mu        sync.Mutex
+	connected bool
+	addrs     []string
+}
+
+// newConnectionEvents creates the events of the route to address.
+func newConnectionEvents(address string) *connectionEvents {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	events := &connectionEvents{route: address, host: host}
+	events.addrs, _ = events.resolve()
+	return events
+}
+
+// emit logs an event and counts it.
+func (e *connectionEvents) emit(event, reason string, fields ...interface{}) {
+	connectionEventsTotal.WithLabelValues(e.route, event, reason).Inc()
+	fields = append([]interface{}{"event", event, "reason", reason, "route", e.route}, fields...)
+	if event == eventConnect || event == eventReconnect {
+		logInfo("Connection event", fields...)
+		return
+	}
+	logWarn("Connection event", fields...)
+}
+
+// dialed records the result of dialing fluentd on startup.
+func (e *connectionEvents) dialed(err error) {
+	e.mu.Lock()
+	e.connected = err == nil
+	e.mu.Unlock()
+
+	if err != nil {
+		e.emit(eventConnectFailed, errorType(err), "error", err)
+		return
+	}
+	e.emit(eventConnect, reasonDial)
+}
+
+// posted records the result of a post to the route's fluentd, emitting an
+// event when the connection state changes.
+func (e *connectionEvents) posted(err error) {
+	e.mu.Lock()
+	changed := e.connected != (err == nil)
+	e.connected = err == nil
+	e.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if err == nil {
+		e.emit(eventReconnect, reasonPost)
+		return
+	}
+	e.emit(eventDisconnect, errorType(err), "error", err)
+
+	// Look up the host outside of the delivery pipeline
+	go e.checkDNS()
+}
+
+// reconnected records a reconnect requested through the admin API.
+func (e *connectionEvents) reconnected() {
+	e.emit(eventReconnect, reasonAdmin)
+}
+
+// checkDNS emits an event when the host resolves to other addresses than
+// before, which explains disconnects caused by replaced aggregators.
+func (e *connectionEvents) checkDNS() {
+	addrs, err := e.resolve()
+	if err != nil {
+		logDebug("Unable to resolve fluentd host", "route", e.route, "host", e.host, "error", err)
+		return
+	}
+
+	e.mu.Lock()
+	previous := e.addrs
+	e.addrs = addrs
+	e.mu.Unlock()
+
+	if previous != nil && strings.Join(previous, ",") != strings.Join(addrs, ",") {
+		e.emit(eventDNSChange, eventDNSChange, "host", e.host,
+			"previous", strings.Join(previous, ","), "current", strings.Join(addrs, ","))
+	}
+}
+
+// resolve looks up the sorted addresses of the host.
+func (e *connectionEvents) resolve() ([]string, error) {
+	addrs, err := net.LookupHost(e.host)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
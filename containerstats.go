@@ -0,0 +1,192 @@
+package fluentd
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultContainerStatsMax = 500
+	otherContainers          = "_other"
+
+	// containerStatsExpiry is how long a container must have been quiet for
+	// its statistics and series to be dropped, e.g. after it was removed.
+	containerStatsExpiry = time.Hour
+
+	// containerStatsSweepInterval is how often expired containers are dropped.
+	containerStatsSweepInterval = time.Minute
+)
+
+var (
+	containerForwarded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "container_records_forwarded_total",
+		Help:      "Records forwarded, by container.",
+	}, []string{"route", "container_name"})
+	containerDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "container_records_dropped_total",
+		Help:      "Records discarded, by container.",
+	}, []string{"route", "container_name"})
+	containerLastForwarded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "container_last_forwarded_timestamp_seconds",
+		Help:      "Time the last record of a container was forwarded.",
+	}, []string{"route", "container_name"})
+)
+
+func init() {
+	prometheus.MustRegister(containerForwarded, containerDropped, containerLastForwarded)
+}
+
+// containerStat is the delivery statistics of one container.
+type containerStat struct {
+	Forwarded     int64     `json:"forwarded"`
+	Dropped       int64     `json:"dropped"`
+	LastForwarded time.Time `json:"last_forwarded"`
+
+	seen           time.Time
+	forwardedTotal prometheus.Counter
+	droppedTotal   prometheus.Counter
+	lastTimestamp  prometheus.Gauge
+}
+
+// containerStats tracks delivery per container name, answering whether logs
+// of a container arrive. To bound the metrics' cardinality, containers beyond
+// CONTAINER_STATS_MAX are accounted together as "_other", and containers
+// without records for containerStatsExpiry are dropped.
+type containerStats struct {
+	route string
+	max   int
+	mu    sync.Mutex
+	stats map[string]*containerStat
+}
+
+// newContainerStats creates statistics for the route to the given address.
+func newContainerStats(route string, max int) *containerStats {
+	return &containerStats{
+		route: route,
+		max:   max,
+		stats: make(map[string]*containerStat),
+	}
+}
+
+// stat returns the statistics of a container. The caller holds the lock.
+func (s *containerStats) stat(name string) *containerStat {
+	name = strings.TrimPrefix(name, "/")
+	if stat, found := s.stats[name]; found {
+		return stat
+	}
+	if len(s.stats) >= s.max {
+		name = otherContainers
+		if stat, found := s.stats[name]; found {
+			return stat
+		}
+	}
+	stat := &containerStat{
+		forwardedTotal: containerForwarded.WithLabelValues(s.route, name),
+		droppedTotal:   containerDropped.WithLabelValues(s.route, name),
+		lastTimestamp:  containerLastForwarded.WithLabelValues(s.route, name),
+	}
+	s.stats[name] = stat
+	return stat
+}
+
+// forwarded accounts a record of the container forwarded at the given time.
+func (s *containerStats) forwarded(container string, now time.Time) {
+	if s.max <= 0 {
+		return
+	}
+	s.mu.Lock()
+	stat := s.stat(container)
+	stat.Forwarded++
+	stat.LastForwarded = now
+	stat.seen = now
+	s.mu.Unlock()
+
+	stat.forwardedTotal.Inc()
+	stat.lastTimestamp.Set(float64(now.Unix()))
+}
+
+// dropped accounts a discarded record of the container.
+func (s *containerStats) dropped(container string) {
+	if s.max <= 0 {
+		return
+	}
+	s.mu.Lock()
+	stat := s.stat(container)
+	stat.Dropped++
+	stat.seen = time.Now()
+	s.mu.Unlock()
+
+	stat.droppedTotal.Inc()
+}
+
+// snapshot returns a copy of the statistics by container name.
+func (s *containerStats) snapshot() map[string]containerStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make(map[string]containerStat, len(s.stats))
+	for name, stat := range s.stats {
+		snapshot[name] = *stat
+	}
+	return snapshot
+}
+
+// expire drops the statistics and series of the containers without records
+// since before, returning how many were dropped.
+func (s *containerStats) expire(before time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := 0
+	for name, stat := range s.stats {
+		if stat.seen.Before(before) {
+			s.remove(name)
+			expired++
+		}
+	}
+	return expired
+}
+
+// close drops the statistics and series of all containers.
+func (s *containerStats) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.stats {
+		s.remove(name)
+	}
+}
+
+// remove drops the statistics and series of a container. The caller holds
+// the lock.
+func (s *containerStats) remove(name string) {
+	delete(s.stats, name)
+	containerForwarded.DeleteLabelValues(s.route, name)
+	containerDropped.DeleteLabelValues(s.route, name)
+	containerLastForwarded.DeleteLabelValues(s.route, name)
+}
+
+// runContainerStats drops the statistics of expired containers until the
+// adapter is closed.
+func (ad *Adapter) runContainerStats() {
+	if ad.containers.max <= 0 {
+		return
+	}
+	ticker := time.NewTicker(containerStatsSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if expired := ad.containers.expire(now.Add(-containerStatsExpiry)); expired > 0 {
+				logDebug("Dropped statistics of quiet containers", "route", ad.address, "containers", expired)
+			}
+		case <-ad.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package fluentd
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cpuLimitWindow is how often CPU usage is measured and the budget enforced.
+const cpuLimitWindow = 100 * time.Millisecond
+
+var (
+	cpuUsage = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "cpu_usage_cores",
+		Help:      "CPU used by the process in the last window, in cores.",
+	})
+	cpuThrottled = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cpu_throttled_seconds_total",
+		Help:      "Time message handling was paused to stay within CPU_LIMIT.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(cpuUsage, cpuThrottled)
+}
+
+// cpuLimiter keeps the process within a CPU budget so logspout never starves
+// co-located containers. It measures the process' CPU time every window and,
+// when the budget was exceeded, pauses message handling for the share of the
+// next window that brings usage back to the budget. Messages then queue up in
+// the buffers in front of the handlers instead of using more CPU.
+type cpuLimiter struct {
+	cores       float64
+	pausedUntil int64
+	routes      int
+	done        chan struct{}
+}
+
+var (
+	cpuLimiterMu   sync.Mutex
+	processLimiter *cpuLimiter
+)
+
+// startCPULimiter starts the process-wide limiter for the given number of
+// cores, or joins the one running; later routes share the first route's
+// budget. It returns nil when there is no budget. Each route using the limiter
+// calls release when it is closed.
+func startCPULimiter(route string, cores float64) *cpuLimiter {
+	if cores <= 0 {
+		return nil
+	}
+	cpuLimiterMu.Lock()
+	defer cpuLimiterMu.Unlock()
+	if processLimiter == nil {
+		processLimiter = &cpuLimiter{cores: cores, done: make(chan struct{})}
+		go processLimiter.run()
+		logInfo("Limiting CPU usage", "cores", cores)
+	} else if cores != processLimiter.cores {
+		logWarn("Routes disagree on CPU_LIMIT, keeping the one in effect", "route", route,
+			"cpu_limit", cores, "cores", processLimiter.cores)
+	}
+	processLimiter.routes++
+	return processLimiter
+}
+
+// release ends a route's use of the limiter, stopping it with the last route.
+func (l *cpuLimiter) release() {
+	if l == nil {
+		return
+	}
+	cpuLimiterMu.Lock()
+	defer cpuLimiterMu.Unlock()
+	l.routes--
+	if l.routes == 0 {
+		close(l.done)
+		processLimiter = nil
+	}
+}
+
+// run measures CPU usage every window and sets the pause, until the limiter
+// is released by the last route.
+func (l *cpuLimiter) run() {
+	ticker := time.NewTicker(cpuLimitWindow)
+	defer ticker.Stop()
+	last, lastCPU := time.Now(), cpuTime()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-l.done:
+			return
+		}
+		used := cpuTime()
+		cores := (used - lastCPU).Seconds() / now.Sub(last).Seconds()
+		last, lastCPU = now, used
+		cpuUsage.Set(cores)
+
+		if cores > l.cores {
+			pause := time.Duration(float64(cpuLimitWindow) * (cores - l.cores) / cores)
+			atomic.StoreInt64(&l.pausedUntil, now.Add(pause).UnixNano())
+		}
+	}
+}
+
+// wait blocks while handling is paused, or until ctx is done.
+func (l *cpuLimiter) wait(ctx context.Context) {
+	if l == nil {
+		return
+	}
+	until := atomic.LoadInt64(&l.pausedUntil)
+	if pause := time.Duration(until - time.Now().UnixNano()); pause > 0 {
+		cpuThrottled.Add(pause.Seconds())
+		timer := time.NewTimer(pause)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// cpuTime returns the user and system CPU time used by the process.
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
@@ -0,0 +1,29 @@
+package fluentd
+
+import "testing"
+
+func TestCPULimiterReleased(t *testing.T) {
+	first := startCPULimiter("first:24224", 2)
+	second := startCPULimiter("second:24224", 4)
+	if first != second || first.cores != 2 {
+		t.Fatalf("routes got limiters of %v and %v cores, want one of 2", first.cores, second.cores)
+	}
+
+	first.release()
+	select {
+	case <-first.done:
+		t.Fatal("limiter stopped while a route still uses it")
+	default:
+	}
+	second.release()
+	select {
+	case <-first.done:
+	default:
+		t.Fatal("limiter running after the last route released it")
+	}
+	if third := startCPULimiter("third:24224", 1); third == first || third.cores != 1 {
+		t.Error("limiter not restarted for a new route")
+	} else {
+		third.release()
+	}
+}
@@ -0,0 +1,27 @@
+package fluentd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// recordTee logs every Nth outgoing record, fully rendered, to the adapter's
+// own log, so enrichment and filters can be verified in production without
+// access to the logging backend. It is disabled with every set to 0.
+type recordTee struct {
+	every uint64
+	count uint64
+}
+
+// newRecordTee creates a tee logging one in every records.
+func newRecordTee(every int) *recordTee {
+	return &recordTee{every: uint64(every)}
+}
+
+// record logs the record if it is the Nth since the last one logged.
+func (t *recordTee) record(destination, tag string, at time.Time, record map[string]string) {
+	if t.every == 0 || atomic.AddUint64(&t.count, 1)%t.every != 0 {
+		return
+	}
+	logInfo("Outgoing record", "destination", destination, "tag", tag, "time", at, "record", record)
+}
@@ -0,0 +1,165 @@
+package fluentd
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultDedupeWindow = 0
+
+	// dedupeSweepInterval is how often runs are checked for an elapsed window.
+	dedupeSweepInterval = time.Second
+)
+
+// repeatRun is a run of identical lines from one container, with the tag and
+// writer its repeats are reported to.
+type repeatRun struct {
+	message *router.Message
+	tag     string
+	writer  Poster
+	count   int
+	start   time.Time
+	last    time.Time
+}
+
+// deduper collapses runs of identical consecutive lines per container. The
+// first line of a run is forwarded as usual and the repeats are reported as a
+// single record with a repeat count once the run ends or the window elapses.
+// Runs whose window elapsed are removed by sweep, so containers that went
+// quiet are neither kept nor left unreported. started is signalled when a run
+// starts, so sweeping only runs while there are runs.
+type deduper struct {
+	mu      sync.Mutex
+	window  time.Duration
+	runs    map[string]*repeatRun
+	started chan struct{}
+}
+
+// newDeduper creates a deduper for the given window. A window of zero disables it.
+func newDeduper(window time.Duration) *deduper {
+	d := &deduper{runs: make(map[string]*repeatRun), started: make(chan struct{}, 1)}
+	d.reconfigure(window)
+	return d
+}
+
+// reconfigure applies a new dedupe window.
+func (d *deduper) reconfigure(window time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.window = window
+}
+
+// check reports whether the message starts a new run and should be forwarded.
+// When a previous run with repeats ends, it is returned so it can be reported.
+func (d *deduper) check(message *router.Message, tag string, writer Poster, now time.Time) (bool, *repeatRun) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.window <= 0 {
+		return true, nil
+	}
+
+	id := message.Container.ID
+	run, found := d.runs[id]
+	if found && run.message.Data == message.Data && run.message.Source == message.Source &&
+		now.Sub(run.start) < d.window {
+		run.count++
+		run.last = message.Time
+		return false, nil
+	}
+
+	d.runs[id] = &repeatRun{message: message, tag: tag, writer: writer, start: now, last: message.Time}
+	select {
+	case d.started <- struct{}{}:
+	default:
+	}
+	if found && run.count > 0 {
+		return true, run
+	}
+	return true, nil
+}
+
+// sweep removes the runs whose window elapsed, returning those with repeats
+// to report and whether runs remain.
+func (d *deduper) sweep(now time.Time) ([]*repeatRun, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var ended []*repeatRun
+	for id, run := range d.runs {
+		if now.Sub(run.start) < d.window {
+			continue
+		}
+		delete(d.runs, id)
+		if run.count > 0 {
+			ended = append(ended, run)
+		}
+	}
+	return ended, len(d.runs) > 0
+}
+
+// flush removes all runs, returning those with repeats to report.
+func (d *deduper) flush() []*repeatRun {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var ended []*repeatRun
+	for id, run := range d.runs {
+		delete(d.runs, id)
+		if run.count > 0 {
+			ended = append(ended, run)
+		}
+	}
+	return ended
+}
+
+// runDedupe reports the repeats of runs whose window elapsed until the
+// adapter is closed. It only sweeps while there are runs, so it doesn't wake
+// an adapter without a dedupe window or whose containers went quiet.
+func (ad *Adapter) runDedupe() {
+	for {
+		select {
+		case <-ad.deduper.started:
+		case <-ad.ctx.Done():
+			return
+		}
+		if !ad.sweepDedupe() {
+			return
+		}
+	}
+}
+
+// sweepDedupe sweeps the runs every dedupeSweepInterval until none remain. It
+// reports false once the adapter is closed.
+func (ad *Adapter) sweepDedupe() bool {
+	ticker := time.NewTicker(dedupeSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			ended, open := ad.deduper.sweep(now)
+			ad.reportRepeats(ended)
+			if !open {
+				return true
+			}
+		case <-ad.ctx.Done():
+			return false
+		}
+	}
+}
+
+// reportRepeats posts a record with the repeat count of each run.
+func (ad *Adapter) reportRepeats(runs []*repeatRun) {
+	for _, run := range runs {
+		record := containerRecord(run.message)
+		record["repeat_count"] = strconv.Itoa(run.count)
+		ad.post(context.Background(), run.writer, run.tag, run.last, record)
+		releaseRecord(record)
+	}
+}
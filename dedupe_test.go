@@ -0,0 +1,118 @@
+package fluentd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeduper(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		window time.Duration
+		lines  []string
+		// offsets of the lines from start
+		offsets    []time.Duration
+		wantKept   []bool
+		wantEnded  []int
+		wantSignal bool
+	}{
+		{
+			name:     "disabled",
+			lines:    []string{"a", "a", "a"},
+			offsets:  []time.Duration{0, 0, 0},
+			wantKept: []bool{true, true, true},
+		},
+		{
+			name:       "repeats",
+			window:     time.Minute,
+			lines:      []string{"a", "a", "a", "b"},
+			offsets:    []time.Duration{0, 1, 2, 3},
+			wantKept:   []bool{true, false, false, true},
+			wantEnded:  []int{0, 0, 0, 2},
+			wantSignal: true,
+		},
+		{
+			name:       "window elapsed",
+			window:     time.Second,
+			lines:      []string{"a", "a", "a"},
+			offsets:    []time.Duration{0, 500 * time.Millisecond, 2 * time.Second},
+			wantKept:   []bool{true, false, true},
+			wantEnded:  []int{0, 0, 1},
+			wantSignal: true,
+		},
+		{
+			name:       "no repeats",
+			window:     time.Minute,
+			lines:      []string{"a", "b"},
+			offsets:    []time.Duration{0, 1},
+			wantKept:   []bool{true, true},
+			wantEnded:  []int{0, 0},
+			wantSignal: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newDeduper(test.window)
+			for i, line := range test.lines {
+				kept, ended := d.check(testMessage("web", line, nil), "tag", nil, start.Add(test.offsets[i]))
+				if kept != test.wantKept[i] {
+					t.Errorf("line %d: kept = %v, want %v", i, kept, test.wantKept[i])
+				}
+				count := 0
+				if ended != nil {
+					count = ended.count
+				}
+				if test.wantEnded != nil && count != test.wantEnded[i] {
+					t.Errorf("line %d: ended run with %d repeats, want %d", i, count, test.wantEnded[i])
+				}
+			}
+			// Sweeping is only armed when a run starts
+			select {
+			case <-d.started:
+				if !test.wantSignal {
+					t.Error("run started while dedupe is disabled")
+				}
+			default:
+				if test.wantSignal {
+					t.Error("no run started")
+				}
+			}
+		})
+	}
+}
+
+func TestDeduperSweep(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	d := newDeduper(time.Second)
+	d.check(testMessage("web", "a", nil), "tag", nil, start)
+	d.check(testMessage("web", "a", nil), "tag", nil, start)
+	d.check(testMessage("api", "b", nil), "tag", nil, start.Add(time.Second))
+
+	ended, open := d.sweep(start.Add(1500 * time.Millisecond))
+	if len(ended) != 1 || ended[0].count != 1 || !open {
+		t.Errorf("sweep = %d runs, open %v; want the repeated run and runs open", len(ended), open)
+	}
+	ended, open = d.sweep(start.Add(3 * time.Second))
+	if len(ended) != 0 || open {
+		t.Errorf("sweep = %d runs, open %v; want no runs left", len(ended), open)
+	}
+}
+
+func TestDedupeReportsRepeats(t *testing.T) {
+	writer := &fakePoster{}
+	ad := newTestAdapter(t, map[string]string{"dedupe_window": "60"}, writer)
+	stream(ad, testMessage("web", "same", nil), testMessage("web", "same", nil),
+		testMessage("web", "same", nil))
+	if err := ad.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	posts := writer.received()
+	if len(posts) != 2 {
+		t.Fatalf("got %d posts, want the first line and the repeat report", len(posts))
+	}
+	if got := posts[1].record["repeat_count"]; got != "2" {
+		t.Errorf("repeat_count = %q, want 2", got)
+	}
+}
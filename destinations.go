@@ -0,0 +1,171 @@
+package fluentd
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultDestinationQueueSize = 1024
+
+// destinationRouter maps values of a container label to named fluentd
+// destinations, each with its own writer and buffer. With a queue size,
+// records are handed to each destination through its own bounded queue and
+// posted from its own goroutine, so a slow destination drops its own records
+// instead of delaying the others.
+type destinationRouter struct {
+	label   string
+	routes  map[string]Poster
+	writers []*namedWriter
+	done    chan struct{}
+}
+
+// newDestinationRouter creates a router sending records of containers whose
+// label has one of the values in routes to the named destination. The queues
+// warn when filled beyond the queueWarn percentages. Async destinations report
+// the outcome of every write to results.
+func newDestinationRouter(route string, destinations []DestinationConfig, label string,
+	routes map[string]string, queueSize int, queueWarn []int, results func(error)) (*destinationRouter, error) {
+	dr := &destinationRouter{
+		label:  label,
+		routes: make(map[string]Poster),
+		done:   make(chan struct{}),
+	}
+	writers := make(map[string]Poster)
+	for _, destination := range destinations {
+		writer, err := newWriter(destination.Writer, results)
+		if err != nil {
+			dr.closeWriters()
+			return nil, errors.Wrapf(err, "Unable to create fluentd destination %s", destination.Name)
+		}
+		named := &namedWriter{Poster: writer, name: destination.Name}
+		if queueSize > 0 {
+			named.queue = make(chan queuedRecord, queueSize)
+			named.watch = newQueueWatch(route, "destination "+destination.Name, destination.Writer.Address,
+				queueSize, queueWarn)
+		}
+		writers[destination.Name] = named
+		dr.writers = append(dr.writers, named)
+	}
+
+	for value, name := range routes {
+		dr.routes[value] = writers[name]
+	}
+	return dr, nil
+}
+
+// writerFor returns the writer for a container with the given labels, or
+// fallback when no route matches.
+func (r *destinationRouter) writerFor(labels map[string]string, fallback Poster) Poster {
+	if r.label == "" {
+		return fallback
+	}
+	if writer, found := r.routes[labels[r.label]]; found {
+		return writer
+	}
+	return fallback
+}
+
+// run posts the queued records of every destination through deliver until
+// the router is closed.
+func (r *destinationRouter) run(deliver func(writer Poster, tag string, t time.Time, record map[string]string)) {
+	for _, writer := range r.writers {
+		if writer.queue == nil {
+			continue
+		}
+		go func(writer *namedWriter) {
+			for {
+				select {
+				case queued := <-writer.queue:
+					writer.watch.observe(len(writer.queue))
+					deliver(writer, queued.tag, queued.time, queued.record)
+				case <-r.done:
+					return
+				}
+			}
+		}(writer)
+	}
+}
+
+// close stops posting queued records. Records still queued are dropped.
+func (r *destinationRouter) close() {
+	close(r.done)
+}
+
+// closeWriters closes the writers of a router that never ran, when creating
+// the adapter failed.
+func (r *destinationRouter) closeWriters() {
+	for _, writer := range r.writers {
+		closeWriter(writer.Poster)
+	}
+}
+
+// pending returns the number of records queued for all destinations.
+func (r *destinationRouter) pending() int {
+	pending := 0
+	for _, writer := range r.writers {
+		pending += len(writer.queue)
+	}
+	return pending
+}
+
+// namedWriter is the writer of a named destination.
+type namedWriter struct {
+	Poster
+	name  string
+	queue chan queuedRecord
+	watch *queueWatch
+}
+
+// enqueue queues a copy of the record for the destination. It never blocks
+// and reports false when the queue is full.
+func (w *namedWriter) enqueue(tag string, t time.Time, record map[string]string) bool {
+	copied := make(map[string]string, len(record))
+	for key, value := range record {
+		copied[key] = value
+	}
+	select {
+	case w.queue <- queuedRecord{tag: tag, time: t, record: copied}:
+		w.watch.observe(len(w.queue))
+		return true
+	default:
+		return false
+	}
+}
+
+// destinationName returns the name of the destination a writer posts to, or
+// "primary" for the route's own fluentd.
+func destinationName(writer Poster) string {
+	if named, ok := writer.(*namedWriter); ok {
+		return named.name
+	}
+	return "primary"
+}
+
+// parseAddress splits an address such as "host:24224?async=true" into the
+// address and its options.
+func parseAddress(spec string) (string, map[string]string, error) {
+	address, query, _ := strings.Cut(spec, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", nil, err
+	}
+	options := make(map[string]string)
+	for key := range values {
+		options[key] = values.Get(key)
+	}
+	return address, options, nil
+}
+
+// splitList splits a comma separated list, ignoring empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
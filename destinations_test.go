@@ -0,0 +1,88 @@
+package fluentd
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dropped returns how many records the adapter dropped for reason.
+func dropped(ad *Adapter, reason string) int {
+	ad.audit.mu.Lock()
+	defer ad.audit.mu.Unlock()
+	return int(ad.audit.counts[reason])
+}
+
+// checkOverflow checks that a slow fluentd received the oldest of count lines
+// sent, in order, and that the newest lines were dropped for reason instead.
+func checkOverflow(t *testing.T, ad *Adapter, fluentd *fakeFluentd, count int, reason string, queueSize int) {
+	t.Helper()
+	drops := dropped(ad, reason)
+	received := fluentd.wait(t, count-drops)
+	if len(received)+drops != count {
+		t.Fatalf("received %d and dropped %d of %d lines", len(received), drops, count)
+	}
+	// The first lines fill the queue and are kept
+	if len(received) < queueSize || drops == 0 {
+		t.Fatalf("received %d and dropped %d lines with a queue of %d", len(received), drops, queueSize)
+	}
+	var lines []int
+	for _, record := range received {
+		line, err := strconv.Atoi(strings.TrimPrefix(record.record["log"], "line "))
+		if err != nil {
+			t.Fatalf("unexpected record %v", record.record)
+		}
+		lines = append(lines, line)
+	}
+	for i := 0; i < queueSize; i++ {
+		if lines[i] != i {
+			t.Fatalf("received lines %v, want the oldest lines", lines)
+		}
+	}
+	if !sort.IntsAreSorted(lines) {
+		t.Errorf("received lines %v out of order", lines)
+	}
+}
+
+func TestDestinationOverflow(t *testing.T) {
+	fluentd := newFakeFluentd(t, nil, 50*time.Millisecond)
+	ad := newTestAdapter(t, map[string]string{
+		"destinations":           "slow=" + fluentd.address() + "?request_ack=true",
+		"route_label":            "service",
+		"routes":                 "checkout=slow",
+		"destination_queue_size": "2",
+		"label_refresh_interval": "0",
+	}, &fakePoster{})
+
+	// Handle the lines one by one, faster than the destination acknowledges
+	for _, message := range lineMessages(20) {
+		message.Container.Config.Labels = map[string]string{"service": "checkout"}
+		ad.handle(ad.ctx, message)
+	}
+	checkOverflow(t, ad, fluentd, 20, dropDestinationOverflow, 2)
+}
+
+func TestDestinationWithoutQueue(t *testing.T) {
+	// Without a queue, posts to the destination wait instead of dropping
+	fluentd := newFakeFluentd(t, nil, 5*time.Millisecond)
+	ad := newTestAdapter(t, map[string]string{
+		"destinations":           "slow=" + fluentd.address() + "?request_ack=true",
+		"route_label":            "service",
+		"routes":                 "checkout=slow",
+		"destination_queue_size": "0",
+		"label_refresh_interval": "0",
+	}, &fakePoster{})
+
+	for _, message := range lineMessages(20) {
+		message.Container.Config.Labels = map[string]string{"service": "checkout"}
+		ad.handle(ad.ctx, message)
+	}
+	if received := len(fluentd.wait(t, 20)); received != 20 {
+		t.Errorf("received %d of 20 lines", received)
+	}
+	if drops := dropped(ad, dropDestinationOverflow); drops != 0 {
+		t.Errorf("dropped %d lines", drops)
+	}
+}
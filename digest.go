@@ -0,0 +1,49 @@
+package fluentd
+
+import "strings"
+
+// imageDigest returns the digest identifying the exact image build of a
+// container: the repository digest when the image reference was pinned with
+// one (repo@sha256:...), and the local image ID otherwise.
+func imageDigest(image, imageID string) string {
+	if _, digest, found := strings.Cut(image, "@"); found {
+		return digest
+	}
+	return imageID
+}
+
+// digestFilter allows or denies records by image digest and optionally adds
+// the digest to every record.
+type digestFilter struct {
+	allow []string
+	deny  []string
+	field bool
+}
+
+// newDigestFilter creates a filter from the image digest configuration.
+// Digests may be abbreviated and given with or without the "sha256:" prefix.
+func newDigestFilter(config ImageDigestConfig) *digestFilter {
+	return &digestFilter{
+		allow: config.Allow,
+		deny:  config.Deny,
+		field: config.Field,
+	}
+}
+
+// allowed reports whether records from an image with the given digest may be forwarded.
+func (f *digestFilter) allowed(digest string) bool {
+	if matchDigest(f.deny, digest) {
+		return false
+	}
+	return len(f.allow) == 0 || matchDigest(f.allow, digest)
+}
+
+func matchDigest(patterns []string, digest string) bool {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	for _, pattern := range patterns {
+		if strings.HasPrefix(digest, strings.TrimPrefix(pattern, "sha256:")) {
+			return true
+		}
+	}
+	return false
+}
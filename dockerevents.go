@@ -0,0 +1,102 @@
+package fluentd
+
+import (
+	"sync"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerEvents shares one docker client and container event listener among
+// the adapters of the process. The client is created when the first adapter
+// subscribes and the listener removed when the last one unsubscribes.
+type dockerEvents struct {
+	mu          sync.Mutex
+	client      *docker.Client
+	events      chan *docker.APIEvents
+	done        chan struct{}
+	subscribers map[int]func(*docker.APIEvents)
+	next        int
+}
+
+var sharedDocker = &dockerEvents{subscribers: make(map[int]func(*docker.APIEvents))}
+
+// subscribe calls handle with every container event until unsubscribe is
+// called, and returns the shared client. The client is nil when docker is not
+// reachable, in which case handle is never called.
+func (d *dockerEvents) subscribe(handle func(*docker.APIEvents)) (*docker.Client, func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.client == nil {
+		client, err := docker.NewClientFromEnv()
+		if err != nil {
+			logWarn("Unable to create docker client", "error", err)
+			return nil, func() {}
+		}
+		events := make(chan *docker.APIEvents, 16)
+		if err := client.AddEventListener(events); err != nil {
+			logWarn("Unable to watch container events", "error", err)
+		} else {
+			d.events, d.done = events, make(chan struct{})
+			go d.dispatch(events, d.done)
+		}
+		d.client = client
+	}
+
+	id := d.next
+	d.next++
+	d.subscribers[id] = handle
+	var once sync.Once
+	return d.client, func() {
+		once.Do(func() { d.unsubscribe(id) })
+	}
+}
+
+// unsubscribe removes a subscriber, releasing the client and listener after
+// the last one.
+func (d *dockerEvents) unsubscribe(id int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.subscribers, id)
+	if len(d.subscribers) > 0 || d.client == nil {
+		return
+	}
+	if d.events != nil {
+		if err := d.client.RemoveEventListener(d.events); err != nil {
+			logDebug("Unable to remove docker event listener", "error", err)
+		}
+		close(d.done)
+		d.events, d.done = nil, nil
+	}
+	d.client = nil
+}
+
+// dispatch hands the events of a listener to the subscribers until the
+// listener is removed and done closed.
+func (d *dockerEvents) dispatch(events chan *docker.APIEvents, done chan struct{}) {
+	for {
+		var event *docker.APIEvents
+		select {
+		case received, ok := <-events:
+			if !ok {
+				return
+			}
+			event = received
+		case <-done:
+			return
+		}
+		if event == nil || event.Type != "container" {
+			continue
+		}
+		d.mu.Lock()
+		handlers := make([]func(*docker.APIEvents), 0, len(d.subscribers))
+		for _, handle := range d.subscribers {
+			handlers = append(handlers, handle)
+		}
+		d.mu.Unlock()
+		for _, handle := range handlers {
+			handle(event)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package fluentd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultDropAlarmThreshold = 1000
+	defaultDropAlarmWindow    = 300
+
+	dropAlarmTimeout = 5 * time.Second
+)
+
+// defaultDropAlarmReasons are the drop reasons that mean data loss rather
+// than intended filtering or sampling, including every failed post.
+var defaultDropAlarmReasons = append([]string{
+	dropParseError,
+	dropRateLimit,
+	dropHostRateLimit,
+	dropTenantQuota,
+	dropMirrorOverflow,
+	dropDestinationOverflow,
+	dropPanic,
+}, postFailureReasons()...)
+
+// postFailureReasons returns the drop reason of every class of failed post.
+func postFailureReasons() []string {
+	reasons := make([]string, len(errorClasses))
+	for i, class := range errorClasses {
+		reasons[i] = dropPostPrefix + class
+	}
+	return reasons
+}
+
+// DropAlarmConfig configures the alarm fired when too many records are
+// dropped within a window.
+type DropAlarmConfig struct {
+	Webhook   string
+	Threshold int64
+	Window    time.Duration
+	Reasons   []string
+}
+
+// dropAlarmEvent is the payload of a fired alarm.
+type dropAlarmEvent struct {
+	Route     string           `json:"route"`
+	Dropped   int64            `json:"dropped"`
+	Threshold int64            `json:"threshold"`
+	Window    string           `json:"window"`
+	Since     time.Time        `json:"since"`
+	Reasons   map[string]int64 `json:"reasons"`
+}
+
+// alarmHook delivers a fired alarm, e.g. to a paging system.
+type alarmHook interface {
+	fire(event dropAlarmEvent) error
+}
+
+// webhookHook posts alarms as JSON to a URL.
+type webhookHook struct {
+	url    string
+	client *http.Client
+}
+
+func (h *webhookHook) fire(event dropAlarmEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// dropAlarm counts drops for the configured reasons in fixed windows and fires
+// its hook once per window in which they reach the threshold, so teams without
+// Prometheus still get paged on silent data loss.
+type dropAlarm struct {
+	route     string
+	hook      alarmHook
+	threshold int64
+	window    time.Duration
+	reasons   map[string]bool
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int64
+	total  int64
+	fired  bool
+}
+
+// newDropAlarm creates the alarm of a route, or nil when no webhook is
+// configured. Without configured reasons, drops meaning data loss count.
+func newDropAlarm(route string, config DropAlarmConfig) *dropAlarm {
+	if config.Webhook == "" {
+		return nil
+	}
+	reasons := config.Reasons
+	if len(reasons) == 0 {
+		reasons = defaultDropAlarmReasons
+	}
+	alarm := &dropAlarm{
+		route:     route,
+		hook:      &webhookHook{url: config.Webhook, client: &http.Client{Timeout: dropAlarmTimeout}},
+		threshold: config.Threshold,
+		window:    config.Window,
+		reasons:   make(map[string]bool),
+		counts:    make(map[string]int64),
+	}
+	for _, reason := range reasons {
+		alarm.reasons[reason] = true
+	}
+	return alarm
+}
+
+// count counts a drop, firing the alarm when the threshold is reached.
+func (a *dropAlarm) count(reason string, now time.Time) {
+	if a == nil || !a.reasons[reason] {
+		return
+	}
+
+	a.mu.Lock()
+	if now.Sub(a.start) >= a.window {
+		a.start, a.counts, a.total, a.fired = now, make(map[string]int64), 0, false
+	}
+	a.counts[reason]++
+	a.total++
+	fire := !a.fired && a.total >= a.threshold
+	var event dropAlarmEvent
+	if fire {
+		a.fired = true
+		event = dropAlarmEvent{
+			Route:     a.route,
+			Dropped:   a.total,
+			Threshold: a.threshold,
+			Window:    a.window.String(),
+			Since:     a.start,
+			Reasons:   make(map[string]int64, len(a.counts)),
+		}
+		for reason, count := range a.counts {
+			event.Reasons[reason] = count
+		}
+	}
+	a.mu.Unlock()
+
+	if fire {
+		logWarn("Drop alarm fired", "route", a.route, "dropped", event.Dropped, "window", a.window)
+		go func() {
+			if err := a.hook.fire(event); err != nil {
+				logError("Unable to deliver drop alarm", "route", a.route, "error", err)
+			}
+		}()
+	}
+}
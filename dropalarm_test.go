@@ -0,0 +1,135 @@
+package fluentd
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHook records the alarms fired.
+type fakeHook struct {
+	mu     sync.Mutex
+	events []dropAlarmEvent
+}
+
+func (h *fakeHook) fire(event dropAlarmEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, event)
+	return nil
+}
+
+// wait waits up to five seconds until count alarms were fired and returns
+// them.
+func (h *fakeHook) wait(t *testing.T, count int) []dropAlarmEvent {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.mu.Lock()
+		events := append([]dropAlarmEvent(nil), h.events...)
+		h.mu.Unlock()
+		if len(events) >= count || time.Now().After(deadline) {
+			return events
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDropAlarm(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		reasons []string
+		drops   []string
+		// offsets of the drops from start
+		offsets   []time.Duration
+		wantFired int
+	}{
+		{
+			name:      "below threshold",
+			drops:     []string{dropRateLimit, dropRateLimit},
+			offsets:   []time.Duration{0, 0},
+			wantFired: 0,
+		},
+		{
+			name:      "threshold reached once per window",
+			drops:     []string{dropRateLimit, dropPanic, dropRateLimit, dropRateLimit},
+			offsets:   []time.Duration{0, 0, 0, 0},
+			wantFired: 1,
+		},
+		{
+			name: "new window",
+			drops: []string{dropRateLimit, dropRateLimit, dropRateLimit,
+				dropRateLimit, dropRateLimit, dropRateLimit},
+			offsets:   []time.Duration{0, 0, 0, time.Minute, time.Minute, time.Minute},
+			wantFired: 2,
+		},
+		{
+			name:      "intended drops",
+			drops:     []string{dropSampling, dropExcluded, dropStreamFilter},
+			offsets:   []time.Duration{0, 0, 0},
+			wantFired: 0,
+		},
+		{
+			name:      "failed posts",
+			drops:     []string{"post_" + errorMaxRetries, "post_" + errorBufferOverflow, "post_" + errorOther},
+			offsets:   []time.Duration{0, 0, 0},
+			wantFired: 1,
+		},
+		{
+			name:      "configured reasons",
+			reasons:   []string{dropSampling},
+			drops:     []string{dropSampling, dropRateLimit, dropSampling, dropSampling},
+			offsets:   []time.Duration{0, 0, 0, 0},
+			wantFired: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			alarm := newDropAlarm("route", DropAlarmConfig{Webhook: "http://alarm", Threshold: 3,
+				Window: time.Minute, Reasons: test.reasons})
+			hook := &fakeHook{}
+			alarm.hook = hook
+			for i, reason := range test.drops {
+				alarm.count(reason, start.Add(test.offsets[i]))
+			}
+			hook.wait(t, test.wantFired)
+			// Give unexpected alarms a moment to arrive
+			time.Sleep(10 * time.Millisecond)
+			if events := hook.wait(t, 0); len(events) != test.wantFired {
+				t.Errorf("fired %d alarms, want %d", len(events), test.wantFired)
+			}
+		})
+	}
+}
+
+func TestDropAlarmDisabled(t *testing.T) {
+	alarm := newDropAlarm("route", DropAlarmConfig{Threshold: 1, Window: time.Minute})
+	if alarm != nil {
+		t.Fatal("alarm created without a webhook")
+	}
+	// A nil alarm ignores drops
+	alarm.count(dropPanic, time.Now())
+}
+
+func TestFailedPostsAudited(t *testing.T) {
+	writer := &fakePoster{err: errors.New("fluent#write: failed to write after 3 attempts")}
+	ad := newTestAdapter(t, map[string]string{"audit_drops": "true", "drop_alarm_webhook": "http://alarm",
+		"drop_alarm_threshold": "2"}, writer)
+	hook := &fakeHook{}
+	ad.audit.alarm.hook = hook
+	stream(ad, testMessage("web", "a", nil), testMessage("web", "b", nil))
+
+	if got := dropped(ad, dropPostPrefix+errorMaxRetries); got != 2 {
+		t.Errorf("audited %d failed posts, want 2", got)
+	}
+	events := hook.wait(t, 1)
+	if len(events) != 1 || events[0].Reasons[dropPostPrefix+errorMaxRetries] != 2 {
+		t.Errorf("alarms = %+v, want one for the failed posts", events)
+	}
+	summary := ad.audit.summary()
+	if summary["dropped_post_max_retries"] != "2" {
+		t.Errorf("audit summary = %v, want 2 failed posts", summary)
+	}
+}
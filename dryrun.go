@@ -0,0 +1,46 @@
+package fluentd
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Poster sends records to a destination. It is implemented by *fluent.Fluent,
+// by ackWriter and, in dry-run mode, by dryRunWriter. Tests and embedding programs pass
+// their own to NewAdapterWithPoster. A Poster that implements io.Closer is
+// closed by Adapter.Close.
+type Poster interface {
+	PostWithTime(tag string, t time.Time, message interface{}) error
+}
+
+// stdoutMu serializes dry-run output of all writers.
+var stdoutMu sync.Mutex
+
+// dryRunWriter prints rendered records as JSON lines to stdout instead of
+// sending them, so configuration changes can be validated locally.
+type dryRunWriter struct {
+	address string
+}
+
+// dryRunRecord is the printed form of a record.
+type dryRunRecord struct {
+	Destination string      `json:"destination"`
+	Tag         string      `json:"tag"`
+	Time        time.Time   `json:"time"`
+	Record      interface{} `json:"record"`
+}
+
+// PostWithTime prints the record.
+func (w *dryRunWriter) PostWithTime(tag string, t time.Time, message interface{}) error {
+	data, err := json.Marshal(dryRunRecord{Destination: w.address, Tag: tag, Time: t, Record: message})
+	if err != nil {
+		return err
+	}
+
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	_, err = os.Stdout.Write(append(data, '\n'))
+	return err
+}
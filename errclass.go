@@ -0,0 +1,64 @@
+package fluentd
+
+import (
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Classes of post failures, separating network issues from payload issues.
+const (
+	errorConnectionRefused = "connection_refused"
+	errorConnectionReset   = "connection_reset"
+	errorBrokenPipe        = "broken_pipe"
+	errorTimeout           = "timeout"
+	errorConnection        = "connection"
+	errorEncode            = "encode"
+	errorBufferOverflow    = "buffer_overflow"
+	errorMaxRetries        = "max_retries"
+	errorOther             = "other"
+)
+
+// errorClasses are the classes errorType returns.
+var errorClasses = []string{
+	errorConnectionRefused, errorConnectionReset, errorBrokenPipe, errorTimeout, errorConnection,
+	errorEncode, errorBufferOverflow, errorMaxRetries, errorOther,
+}
+
+// errorType classifies a post failure for metrics and logs.
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errorConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET):
+		return errorConnectionReset
+	case errors.Is(err, syscall.EPIPE):
+		return errorBrokenPipe
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return errorTimeout
+		}
+		return errorConnection
+	}
+
+	// The fluent logger reports payload, buffer and retry failures as plain
+	// errors
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "buffer full"):
+		return errorBufferOverflow
+	case strings.Contains(message, "could not connect to fluentd after") ||
+		strings.Contains(message, "failed to write after"):
+		return errorMaxRetries
+	case strings.Contains(message, "encode") || strings.Contains(message, "msgp") ||
+		strings.Contains(message, "marshal"):
+		return errorEncode
+	case strings.Contains(message, "timeout"):
+		return errorTimeout
+	}
+	return errorOther
+}
@@ -0,0 +1,34 @@
+package fluentd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, errorConnectionRefused},
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, errorConnectionReset},
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, errorBrokenPipe},
+		{&net.DNSError{Err: "i/o timeout", IsTimeout: true}, errorTimeout},
+		{&net.DNSError{Err: "no such host"}, errorConnection},
+		// The errors of the fluent logger
+		{errors.New("fluent#appendBuffer: Buffer full, limit 1048576"), errorBufferOverflow},
+		{fmt.Errorf("could not connect to fluentd after %d retries", 10), errorMaxRetries},
+		{fmt.Errorf("fluent#write: failed to write after %d attempts", 10), errorMaxRetries},
+		{errors.New("msgp: too few bytes left to read object"), errorEncode},
+		{errors.New("something else"), errorOther},
+	}
+	for _, test := range tests {
+		if got := errorType(test.err); got != test.want {
+			t.Errorf("errorType(%v) = %s, want %s", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package fluentd
+
+import (
+	"sync"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/google/cel-go/cel"
+	"github.com/pkg/errors"
+)
+
+const defaultFilterExpressionLabel = "fluentd.filter"
+
+// expressionFilter forwards only records for which a CEL expression evaluates
+// to true, e.g. `container.labels["tier"] == "db" && msg.matches("slow query")`.
+// The global expression comes from FILTER_EXPRESSION and may be replaced per
+// container by a label. Compiled programs are cached by expression.
+type expressionFilter struct {
+	env        *cel.Env
+	expression string
+	label      string
+
+	mu       sync.Mutex
+	programs map[string]cel.Program
+}
+
+// newExpressionFilter creates a filter for the given global expression and
+// the label that overrides it per container.
+func newExpressionFilter(expression, label string) (*expressionFilter, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("msg", cel.StringType),
+		cel.Variable("source", cel.StringType),
+		cel.Variable("tag", cel.StringType),
+		cel.Variable("container", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to create filter expression environment")
+	}
+
+	f := &expressionFilter{
+		env:        env,
+		expression: expression,
+		label:      label,
+		programs:   make(map[string]cel.Program),
+	}
+	if f.expression != "" {
+		if _, err := f.compile(f.expression); err != nil {
+			return nil, errors.Wrapf(err, "Invalid FILTER_EXPRESSION")
+		}
+	}
+	return f, nil
+}
+
+// allow reports whether the message passes the expression that applies to its
+// container. Expressions that fail to compile or evaluate let records through,
+// returning the failure so it can be handled according to STRICT_MODE.
+func (f *expressionFilter) allow(message *router.Message, labels map[string]string, tag string) (bool, error) {
+	expression := f.expression
+	if value, found := labels[f.label]; found && f.label != "" {
+		expression = value
+	}
+	if expression == "" {
+		return true, nil
+	}
+
+	program, err := f.program(expression)
+	if err != nil {
+		return true, err
+	}
+
+	out, _, err := program.Eval(map[string]interface{}{
+		"msg":    message.Data,
+		"source": message.Source,
+		"tag":    tag,
+		"container": map[string]interface{}{
+			"id":       message.Container.ID,
+			"name":     message.Container.Name,
+			"image":    message.Container.Config.Image,
+			"digest":   imageDigest(message.Container.Config.Image, message.Container.Image),
+			"hostname": message.Container.Config.Hostname,
+			"labels":   labels,
+		},
+	})
+	if err != nil {
+		logDebug("Filter expression evaluation failed", "expression", expression, "error", err)
+		return true, errors.Wrap(err, "filter expression")
+	}
+	allowed, ok := out.Value().(bool)
+	if !ok {
+		return true, errors.Errorf("filter expression %q is not a boolean", expression)
+	}
+	return allowed, nil
+}
+
+// program returns the cached program for an expression, compiling it on first
+// use. Compilation failures are cached too so they are only logged once.
+func (f *expressionFilter) program(expression string) (cel.Program, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if program, found := f.programs[expression]; found {
+		if program == nil {
+			return nil, errors.New("Invalid filter expression")
+		}
+		return program, nil
+	}
+	program, err := f.compile(expression)
+	if err != nil {
+		logError("Invalid filter expression", "expression", expression, "error", err)
+	}
+	f.programs[expression] = program
+	return program, err
+}
+
+func (f *expressionFilter) compile(expression string) (cel.Program, error) {
+	ast, issues := f.env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	return f.env.Program(ast)
+}
@@ -0,0 +1,57 @@
+package fluentd
+
+import "testing"
+
+func TestExpressionFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		labels     map[string]string
+		want       bool
+		wantErr    bool
+	}{
+		{name: "no expression", want: true},
+		{name: "message", expression: `msg.contains("slow")`, want: true},
+		{name: "message excluded", expression: `msg.contains("fast")`, want: false},
+		{
+			name:       "labels",
+			expression: `container.labels["tier"] == "db" && source == "stdout"`,
+			labels:     map[string]string{"tier": "db"},
+			want:       true,
+		},
+		{name: "tag", expression: `tag.startsWith("docker.")`, want: true},
+		{
+			name:       "label override",
+			expression: `false`,
+			labels:     map[string]string{defaultFilterExpressionLabel: `container.name == "web"`},
+			want:       true,
+		},
+		// Failing expressions let records through
+		{
+			name:       "invalid label expression",
+			labels:     map[string]string{defaultFilterExpressionLabel: `msg ==`},
+			want:       true,
+			wantErr:    true,
+			expression: `false`,
+		},
+		{name: "not a boolean", expression: `msg`, want: true, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := newExpressionFilter(test.expression, defaultFilterExpressionLabel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := f.allow(testMessage("web", "slow query", test.labels), test.labels, "docker.web")
+			if got != test.want || (err != nil) != test.wantErr {
+				t.Errorf("allow = %v, %v; want %v, error %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpressionFilterInvalid(t *testing.T) {
+	if _, err := newExpressionFilter(`msg ==`, defaultFilterExpressionLabel); err == nil {
+		t.Error("invalid FILTER_EXPRESSION accepted")
+	}
+}
@@ -0,0 +1,50 @@
+package fluentd
+
+import (
+	"expvar"
+	"sync"
+)
+
+// expvarRoutes holds the counters of every route with EXPVAR enabled, by
+// fluentd address. It is published as fluentd_adapter on /debug/vars of the
+// default mux and of METRICS_ADDRESS once the first route enables it, for
+// environments without Prometheus.
+var (
+	expvarRoutes  = new(expvar.Map).Init()
+	expvarPublish sync.Once
+)
+
+// routeVars are the expvar counters of a single route.
+type routeVars struct {
+	counters  *expvar.Map
+	queue     *expvar.Int
+	connected *expvar.Int
+}
+
+// newRouteVars creates the counters of a route, see publish.
+func newRouteVars() *routeVars {
+	vars := &routeVars{
+		counters:  new(expvar.Map).Init(),
+		queue:     new(expvar.Int),
+		connected: new(expvar.Int),
+	}
+	vars.counters.Set("mirror_queue_depth", vars.queue)
+	vars.counters.Set("connected", vars.connected)
+	return vars
+}
+
+// publish publishes the counters of the route to the given address.
+func (v *routeVars) publish(route string) {
+	expvarPublish.Do(func() {
+		expvar.Publish(metricsNamespace, expvarRoutes)
+	})
+	expvarRoutes.Set(route, v.counters)
+}
+
+// unpublish removes the counters of a closed route, unless another adapter
+// for the same address published its own since.
+func (v *routeVars) unpublish(route string) {
+	if expvarRoutes.Get(route) == v.counters {
+		expvarRoutes.Delete(route)
+	}
+}
@@ -0,0 +1,77 @@
+package fluentd
+
+import (
+	"sync"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// costExtension is the cost of registered filters, which run after the
+// built-in ones as their cost is unknown.
+const costExtension = costExpression + 1
+
+// Filter reports whether a message is forwarded. Filters run after the
+// built-in filters and must be safe for concurrent use.
+type Filter func(message *router.Message) bool
+
+// Enricher adds or changes fields of the record of a message before it is
+// posted, or returns false to drop the message. Enrichers run in order of
+// registration after the built-in fields are set, and must be safe for
+// concurrent use. They must not keep the record, which is reused once posted.
+type Enricher func(message *router.Message, record map[string]string) bool
+
+// extensions are the filters and enrichers registered by custom builds.
+var extensions struct {
+	mu        sync.Mutex
+	names     map[string]bool
+	filters   filterChain
+	enrichers middlewareChain
+}
+
+// RegisterFilter registers a filter for all adapters created afterwards,
+// usually from the init function of a custom build. Messages it drops are
+// counted with the name as the drop reason. It panics if the name is taken.
+func RegisterFilter(name string, filter Filter) {
+	extensions.mu.Lock()
+	defer extensions.mu.Unlock()
+	claim(name)
+	extensions.filters = append(extensions.filters, filterStage{
+		reason: name,
+		cost:   costExtension,
+		allow: func(d *delivery) bool {
+			return filter(d.message)
+		},
+	})
+}
+
+// RegisterEnricher registers an enricher for all adapters created afterwards,
+// usually from the init function of a custom build. Messages it drops are
+// counted with the reason "middleware". It panics if the name is taken.
+func RegisterEnricher(name string, enricher Enricher) {
+	extensions.mu.Lock()
+	defer extensions.mu.Unlock()
+	claim(name)
+	extensions.enrichers = extensions.enrichers.use(func(d *delivery, record map[string]string) (map[string]string, bool) {
+		return record, enricher(d.message, record)
+	})
+}
+
+// claim claims an extension name. extensions.mu must be held.
+func claim(name string) {
+	if extensions.names == nil {
+		extensions.names = make(map[string]bool)
+	}
+	if extensions.names[name] {
+		panic("fluentd: extension registered twice: " + name)
+	}
+	extensions.names[name] = true
+}
+
+// withExtensions returns the filters and middlewares of a pipeline followed
+// by the registered ones.
+func withExtensions(filters filterChain, middlewares middlewareChain) (filterChain, middlewareChain) {
+	extensions.mu.Lock()
+	defer extensions.mu.Unlock()
+	filters = append(filters[:len(filters):len(filters)], extensions.filters...)
+	return filters, middlewares.use(extensions.enrichers...)
+}
@@ -0,0 +1,95 @@
+package fluentd
+
+import (
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFaultAdapter creates an adapter posting with acknowledgements to the
+// fake fluentd, retrying quickly.
+func newFaultAdapter(t testing.TB, fluentd *fakeFluentd) *Adapter {
+	t.Helper()
+	config, err := LoadConfig(fluentd.address(), map[string]string{
+		"request_ack":            "true",
+		"max_retries":            "10",
+		"retry_wait":             "1",
+		"write_timeout":          "1",
+		"label_refresh_interval": "0",
+		"drain_timeout":          "5",
+	})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	ad, err := NewAdapterFromConfig(*config)
+	if err != nil {
+		t.Fatalf("NewAdapterFromConfig: %v", err)
+	}
+	t.Cleanup(func() { ad.Close() })
+	return ad
+}
+
+func TestForwardFaults(t *testing.T) {
+	for _, injected := range []fault{faultDisconnect, faultPartial, faultNack} {
+		t.Run(injected.String(), func(t *testing.T) {
+			// Every third message fails once and is retried
+			fluentd := newFakeFluentd(t, func(n int) fault {
+				if n%3 == 1 {
+					return injected
+				}
+				return faultNone
+			}, 0)
+			stream(newFaultAdapter(t, fluentd), lineMessages(20)...)
+
+			lines := fluentd.lines()
+			for i := 0; i < 20; i++ {
+				if line := "line " + strconv.Itoa(i); lines[line] == 0 {
+					t.Errorf("%q was not delivered", line)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardFaultsSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+	// Process-wide goroutines start with the first adapter
+	fluentd := newFakeFluentd(t, nil, 0)
+	stream(newFaultAdapter(t, fluentd), lineMessages(1)...)
+	fluentd.close()
+	before := runtime.NumGoroutine()
+
+	faults := rand.New(rand.NewSource(1))
+	var mu sync.Mutex
+	for round := 0; round < 5; round++ {
+		fluentd := newFakeFluentd(t, func(int) fault {
+			mu.Lock()
+			defer mu.Unlock()
+			if faults.Intn(5) == 0 {
+				return fault(1 + faults.Intn(3))
+			}
+			return faultNone
+		}, 0)
+		ad := newFaultAdapter(t, fluentd)
+		stream(ad, lineMessages(200)...)
+		if err := ad.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		fluentd.close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		stacks := make([]byte, 1<<20)
+		stacks = stacks[:runtime.Stack(stacks, true)]
+		t.Errorf("%d goroutines leaked:\n%s", after-before, stacks)
+	}
+}
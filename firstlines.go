@@ -0,0 +1,84 @@
+package fluentd
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultForwardFirstLines    = 0
+	defaultForwardFirstLinesGap = 60
+)
+
+// streamStart tracks how many lines a container has logged since it was first
+// seen or since it was last silent for longer than the gap.
+type streamStart struct {
+	count int
+	last  time.Time
+}
+
+// startupGuard guarantees that the first lines of a container, and the first
+// lines after each gap, bypass sampling and rate limiting so startup errors
+// and crash messages are never lost.
+type startupGuard struct {
+	mu        sync.Mutex
+	lines     int
+	gap       time.Duration
+	streams   map[string]*streamStart
+	lastSweep time.Time
+}
+
+// newStartupGuard creates a guard forwarding the first lines of each stream,
+// which starts over after a container was silent for gap.
+func newStartupGuard(lines int, gap time.Duration) *startupGuard {
+	g := &startupGuard{streams: make(map[string]*streamStart)}
+	g.reconfigure(lines, gap)
+	return g
+}
+
+// reconfigure applies a new line count and gap. A gap of zero, which would
+// make every line start a stream, is replaced by the default.
+func (g *startupGuard) reconfigure(lines int, gap time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if gap <= 0 {
+		gap = defaultForwardFirstLinesGap * time.Second
+	}
+	g.lines = lines
+	g.gap = gap
+}
+
+// guaranteed reports whether the next line of the container must be forwarded.
+func (g *startupGuard) guaranteed(containerID string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.lines <= 0 {
+		return false
+	}
+
+	g.sweep(now)
+	stream, found := g.streams[containerID]
+	if !found || now.Sub(stream.last) >= g.gap {
+		stream = &streamStart{}
+		g.streams[containerID] = stream
+	}
+	stream.count++
+	stream.last = now
+	return stream.count <= g.lines
+}
+
+// sweep forgets containers that have been silent for longer than the gap, as
+// their next line starts over anyway.
+func (g *startupGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < g.gap {
+		return
+	}
+	g.lastSweep = now
+	for id, stream := range g.streams {
+		if now.Sub(stream.last) >= g.gap {
+			delete(g.streams, id)
+		}
+	}
+}
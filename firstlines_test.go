@@ -0,0 +1,78 @@
+package fluentd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStartupGuard(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name       string
+		lines      int
+		containers []string
+		// offsets of the lines from start
+		offsets []time.Duration
+		want    []bool
+	}{
+		{
+			name:       "disabled",
+			containers: []string{"a", "a"},
+			offsets:    []time.Duration{0, 0},
+			want:       []bool{false, false},
+		},
+		{
+			name:       "first lines",
+			lines:      2,
+			containers: []string{"a", "a", "a"},
+			offsets:    []time.Duration{0, 1, 2},
+			want:       []bool{true, true, false},
+		},
+		{
+			name:       "per container",
+			lines:      1,
+			containers: []string{"a", "b", "a", "b"},
+			offsets:    []time.Duration{0, 0, 1, 1},
+			want:       []bool{true, true, false, false},
+		},
+		{
+			name:       "after a gap",
+			lines:      1,
+			containers: []string{"a", "a", "a"},
+			offsets:    []time.Duration{0, time.Second, 2 * time.Minute},
+			want:       []bool{true, false, true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := newStartupGuard(test.lines, time.Minute)
+			for i, container := range test.containers {
+				if got := g.guaranteed(container, start.Add(test.offsets[i])); got != test.want[i] {
+					t.Errorf("line %d: guaranteed = %v, want %v", i, got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFirstLinesBypassRateLimit(t *testing.T) {
+	writer := &fakePoster{}
+	ad := newTestAdapter(t, map[string]string{"forward_first_lines": "3", "rate_limit_lines": "1"}, writer)
+	stream(ad, testMessage("web", "a", nil), testMessage("web", "b", nil), testMessage("web", "c", nil),
+		testMessage("web", "d", nil), testMessage("web", "e", nil))
+
+	var lines []string
+	for _, post := range writer.received() {
+		lines = append(lines, post.record["log"])
+	}
+	// The first line after them takes the burst, the last one is reported
+	// as suppressed on Close
+	want := []string{"a", "b", "c", "d", "suppressed 1 lines"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("posted %q, want %q", lines, want)
+	}
+	if got := dropped(ad, dropRateLimit); got != 1 {
+		t.Errorf("rate limited %d lines, want 1", got)
+	}
+}
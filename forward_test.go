@@ -0,0 +1,302 @@
+package fluentd
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// fault is a failure a fakeFluentd injects into the handling of a message.
+type fault int
+
+const (
+	faultNone fault = iota
+	// faultDisconnect hangs up before reading the message
+	faultDisconnect
+	// faultPartial reads part of the message, then hangs up
+	faultPartial
+	// faultNack acknowledges the message with another chunk id
+	faultNack
+)
+
+func (f fault) String() string {
+	return [...]string{"none", "disconnect", "partial", "nack"}[f]
+}
+
+// forwarded is a record received by a fakeFluentd.
+type forwarded struct {
+	tag    string
+	time   int64
+	record map[string]string
+}
+
+// fakeFluentd accepts records over the forward protocol. It acknowledges the
+// messages that request it, after the given latency, and injects the fault
+// chosen by faults, if set, for the nth message received.
+type fakeFluentd struct {
+	listener net.Listener
+	faults   func(n int) fault
+	latency  time.Duration
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	n       int
+	conns   map[net.Conn]bool
+	records []forwarded
+}
+
+// newFakeFluentd starts a fake fluentd on a random port, stopped when the test
+// ends.
+func newFakeFluentd(t testing.TB, faults func(n int) fault, latency time.Duration) *fakeFluentd {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	f := &fakeFluentd{
+		listener: listener,
+		faults:   faults,
+		latency:  latency,
+		conns:    make(map[net.Conn]bool),
+	}
+	f.wg.Add(1)
+	go f.accept()
+	t.Cleanup(f.close)
+	return f
+}
+
+// address returns the address the fake fluentd listens on.
+func (f *fakeFluentd) address() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeFluentd) accept() {
+	defer f.wg.Done()
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns[conn] = true
+		f.mu.Unlock()
+		f.wg.Add(1)
+		go f.serve(conn)
+	}
+}
+
+// serve reads messages from a connection until it is closed or a fault hangs
+// up.
+func (f *fakeFluentd) serve(conn net.Conn) {
+	defer f.wg.Done()
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := msgp.NewReader(conn)
+	for {
+		// Wait for the next message before deciding its fault
+		if _, err := reader.R.Peek(1); err != nil {
+			return
+		}
+		f.mu.Lock()
+		fault := faultNone
+		if f.faults != nil {
+			fault = f.faults(f.n)
+		}
+		f.n++
+		f.mu.Unlock()
+
+		switch fault {
+		case faultDisconnect:
+			return
+		case faultPartial:
+			reader.R.Next(reader.R.Buffered() / 2)
+			return
+		}
+
+		message, err := reader.ReadIntf()
+		if err != nil {
+			return
+		}
+		// A message is [tag, time, record, option]
+		fields, ok := message.([]interface{})
+		if !ok || len(fields) != 4 {
+			return
+		}
+		received := forwarded{record: make(map[string]string)}
+		received.tag, _ = fields[0].(string)
+		received.time, _ = fields[1].(int64)
+		record, _ := fields[2].(map[string]interface{})
+		for key, value := range record {
+			received.record[key], _ = value.(string)
+		}
+		option, _ := fields[3].(map[string]interface{})
+		chunk, _ := option["chunk"].(string)
+		time.Sleep(f.latency)
+
+		if fault == faultNack {
+			chunk = "nack-" + chunk
+		} else {
+			f.mu.Lock()
+			f.records = append(f.records, received)
+			f.mu.Unlock()
+		}
+		if _, found := option["chunk"]; !found {
+			continue
+		}
+		ack := msgp.AppendMapHeader(nil, 1)
+		ack = msgp.AppendString(ack, "ack")
+		ack = msgp.AppendString(ack, chunk)
+		if _, err := conn.Write(ack); err != nil {
+			return
+		}
+	}
+}
+
+// received returns the records received so far. Records whose
+// acknowledgement was lost may have been received more than once.
+func (f *fakeFluentd) received() []forwarded {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]forwarded(nil), f.records...)
+}
+
+// wait waits up to five seconds until count records were received and
+// returns them.
+func (f *fakeFluentd) wait(t testing.TB, count int) []forwarded {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		received := f.received()
+		if len(received) >= count || time.Now().After(deadline) {
+			return received
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// lines returns how often each line was received.
+func (f *fakeFluentd) lines() map[string]int {
+	lines := make(map[string]int)
+	for _, received := range f.received() {
+		lines[received.record["log"]]++
+	}
+	return lines
+}
+
+// close stops accepting connections and hangs up the open ones.
+func (f *fakeFluentd) close() {
+	f.listener.Close()
+	f.mu.Lock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+}
+
+// testTransport dials plain TCP as logspout's tcp transport does.
+type testTransport struct{}
+
+func (testTransport) Dial(address string, options map[string]string) (net.Conn, error) {
+	return net.Dial("tcp", address)
+}
+
+func init() {
+	router.AdapterTransports.Register(testTransport{}, "test")
+}
+
+// newForwardAdapter creates an adapter for a logspout route to the fake
+// fluentd, closed when the test ends.
+func newForwardAdapter(t testing.TB, fluentd *fakeFluentd, options map[string]string) *Adapter {
+	t.Helper()
+	if options == nil {
+		options = make(map[string]string)
+	}
+	options["label_refresh_interval"] = "0"
+	adapter, err := NewAdapter(&router.Route{Adapter: "fluentd+test", Address: fluentd.address(), Options: options})
+	if err != nil {
+		t.Fatalf("NewAdapter: %v", err)
+	}
+	ad := adapter.(*Adapter)
+	t.Cleanup(func() { ad.Close() })
+	return ad
+}
+
+func TestForward(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		latency time.Duration
+	}{
+		{name: "sync"},
+		{name: "ack", options: map[string]string{"request_ack": "true"}},
+		{name: "async", options: map[string]string{"async": "true"}},
+		{name: "slow ack", options: map[string]string{"request_ack": "true"}, latency: 5 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fluentd := newFakeFluentd(t, nil, test.latency)
+			ad := newForwardAdapter(t, fluentd, test.options)
+			stream(ad, testMessage("web", "one", nil), testMessage("api", "two", map[string]string{"env": "prod"}))
+
+			received := fluentd.wait(t, 2)
+			if len(received) != 2 {
+				t.Fatalf("received %d records, want 2: %v", len(received), received)
+			}
+			want := []forwarded{
+				{tag: "docker.web-host", time: 1700000000, record: map[string]string{
+					"log": "one", "container_id": "id-web", "container_name": "web", "source": "stdout"}},
+				{tag: "docker.api-host", time: 1700000000, record: map[string]string{
+					"log": "two", "container_id": "id-api", "container_name": "api", "source": "stdout"}},
+			}
+			for i, got := range received {
+				if got.tag != want[i].tag || got.time != want[i].time {
+					t.Errorf("record %d: tag %q at %d, want %q at %d", i, got.tag, got.time, want[i].tag,
+						want[i].time)
+				}
+				for key, value := range want[i].record {
+					if got.record[key] != value {
+						t.Errorf("record %d: %s = %q, want %q", i, key, got.record[key], value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestForwardOrder(t *testing.T) {
+	fluentd := newFakeFluentd(t, nil, 0)
+	ad := newForwardAdapter(t, fluentd, map[string]string{"request_ack": "true", "workers": "4"})
+	stream(ad, lineMessages(100)...)
+
+	received := fluentd.wait(t, 100)
+	if len(received) != 100 {
+		t.Fatalf("received %d records, want 100", len(received))
+	}
+	// The lines of a container keep their order across workers
+	for i, got := range received {
+		if want := "line " + strconv.Itoa(i); got.record["log"] != want {
+			t.Fatalf("record %d is %q, want %q", i, got.record["log"], want)
+		}
+	}
+}
+
+// lineMessages returns count messages of one container with the lines
+// "line 0" and up.
+func lineMessages(count int) []*router.Message {
+	messages := make([]*router.Message, count)
+	for i := range messages {
+		messages[i] = testMessage("web", "line "+strconv.Itoa(i), nil)
+	}
+	return messages
+}
@@ -0,0 +1,102 @@
+package fluentd
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultReadyTolerance = 30
+
+// routeHealth tracks whether a route delivers to its fluentd. A route is ready
+// while posts succeed, and keeps being ready for the tolerance after posts
+// start failing, which the fluent logger's buffer and retries usually absorb.
+type routeHealth struct {
+	route        string
+	mu           sync.Mutex
+	tolerance    time.Duration
+	failingSince time.Time
+}
+
+// failed records a failed post.
+func (h *routeHealth) failed(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failingSince.IsZero() {
+		h.failingSince = now
+	}
+}
+
+// succeeded records a successful post.
+func (h *routeHealth) succeeded() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failingSince = time.Time{}
+}
+
+// setTolerance sets how long posts may fail before the route is not ready.
+func (h *routeHealth) setTolerance(tolerance time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tolerance = tolerance
+}
+
+// ready returns an error when posts have been failing for longer than the
+// tolerance.
+func (h *routeHealth) ready(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.failingSince.IsZero() && now.Sub(h.failingSince) > h.tolerance {
+		return errors.Errorf("route %s failing to post since %s", h.route, h.failingSince.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// healthChecks are the routes served by one health endpoint.
+type healthChecks struct {
+	mu     sync.Mutex
+	routes []*routeHealth
+}
+
+func (c *healthChecks) add(health *routeHealth) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes = append(c.routes, health)
+}
+
+// remove stops checking the health of a closed route.
+func (c *healthChecks) remove(health *routeHealth) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, route := range c.routes {
+		if route == health {
+			c.routes = append(c.routes[:i:i], c.routes[i+1:]...)
+			return
+		}
+	}
+}
+
+// ready serves /ready, failing while any route is wedged so orchestrators can
+// restart logspout.
+func (c *healthChecks) ready(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	routes := c.routes
+	c.mu.Unlock()
+
+	now := time.Now()
+	for _, health := range routes {
+		if err := health.ready(now); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	io.WriteString(w, "ok\n")
+}
+
+// healthz serves /healthz, which succeeds as long as the process serves HTTP.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok\n")
+}
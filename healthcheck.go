@@ -0,0 +1,28 @@
+package fluentd
+
+import "regexp"
+
+// healthCheckPatterns match access log lines produced by common load balancer
+// and orchestrator health checks.
+var healthCheckPatterns = []*regexp.Regexp{
+	// AWS ELB/ALB target health checks
+	regexp.MustCompile(`ELB-HealthChecker/`),
+	// Kubernetes liveness/readiness probes
+	regexp.MustCompile(`kube-probe/`),
+	// Google Cloud load balancer health checks
+	regexp.MustCompile(`GoogleHC/`),
+	// Consul HTTP checks
+	regexp.MustCompile(`Consul Health Check`),
+	// Successful requests to conventional health endpoints
+	regexp.MustCompile(`"?(GET|HEAD) /(healthz|health|healthcheck|livez|readyz|ready|ping)/?(\?\S*)?( HTTP/[0-9.]+)?"? 200\b`),
+}
+
+// isHealthCheck reports whether a log line looks like health check noise.
+func isHealthCheck(data string) bool {
+	for _, pattern := range healthCheckPatterns {
+		if pattern.MatchString(data) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package fluentd
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const hostLimitWindow = time.Second
+
+// containerUsage is the usage and demand of one container within a window.
+type containerUsage struct {
+	lines       float64
+	bytes       float64
+	demandLines float64
+	demandBytes float64
+}
+
+// hostLimiter enforces host-wide lines/sec and bytes/sec ceilings. Capacity is
+// shared max-min fairly: each container may use up to a cap computed from the
+// demand observed in the previous window, so quiet containers keep their share
+// while a noisy one only gets what is left.
+type hostLimiter struct {
+	mu          sync.Mutex
+	lineLimit   float64
+	byteLimit   float64
+	windowStart time.Time
+	lines       float64
+	bytes       float64
+	usage       map[string]*containerUsage
+	lineCap     float64
+	byteCap     float64
+	dropped     int
+	lastReport  time.Time
+	config      HostRateLimitConfig
+}
+
+var (
+	hostLimiterOnce    sync.Once
+	processHostLimiter *hostLimiter
+)
+
+// sharedHostLimiter returns the process-wide host limiter, created once with
+// the first route's ceilings; the ceilings are host-wide, so every route
+// draws from the same capacity.
+func sharedHostLimiter(route string, config HostRateLimitConfig) *hostLimiter {
+	hostLimiterOnce.Do(func() {
+		processHostLimiter = newHostLimiter(config)
+	})
+	h := processHostLimiter
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if config != h.config {
+		logWarn("Routes disagree on the host rate limit, keeping the one in effect", "route", route,
+			"lines", h.config.Lines, "bytes", h.config.Bytes)
+	}
+	return h
+}
+
+// newHostLimiter creates a host limiter from the host rate limit
+// configuration. A limit of zero disables that ceiling.
+func newHostLimiter(config HostRateLimitConfig) *hostLimiter {
+	h := &hostLimiter{
+		usage:   make(map[string]*containerUsage),
+		lineCap: math.Inf(1),
+		byteCap: math.Inf(1),
+	}
+	h.reconfigure(config)
+	return h
+}
+
+// reconfigure applies new ceilings, taking effect from the next window. The
+// ceilings of the shared limiter apply to every route.
+func (h *hostLimiter) reconfigure(config HostRateLimitConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.config = config
+	h.lineLimit = float64(config.Lines)
+	h.byteLimit = float64(config.Bytes)
+}
+
+// allow reports whether a line of the given size from the given container fits
+// within the host-wide ceilings.
+func (h *hostLimiter) allow(containerID string, size int, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.lineLimit <= 0 && h.byteLimit <= 0 {
+		return true
+	}
+
+	if now.Sub(h.windowStart) >= hostLimitWindow {
+		h.rollover(now)
+	}
+
+	usage, found := h.usage[containerID]
+	if !found {
+		usage = &containerUsage{}
+		h.usage[containerID] = usage
+	}
+	usage.demandLines++
+	usage.demandBytes += float64(size)
+
+	if h.lineLimit > 0 && (h.lines+1 > h.lineLimit || usage.lines+1 > h.lineCap) {
+		h.dropped++
+		return false
+	}
+	if h.byteLimit > 0 && (h.bytes+float64(size) > h.byteLimit || usage.bytes+float64(size) > h.byteCap) {
+		h.dropped++
+		return false
+	}
+
+	h.lines++
+	h.bytes += float64(size)
+	usage.lines++
+	usage.bytes += float64(size)
+	return true
+}
+
+// rollover starts a new window, deriving per-container caps from the demand
+// seen in the window that just ended.
+func (h *hostLimiter) rollover(now time.Time) {
+	lineDemands := make([]float64, 0, len(h.usage))
+	byteDemands := make([]float64, 0, len(h.usage))
+	for _, usage := range h.usage {
+		lineDemands = append(lineDemands, usage.demandLines)
+		byteDemands = append(byteDemands, usage.demandBytes)
+	}
+	h.lineCap = fairShare(lineDemands, h.lineLimit)
+	h.byteCap = fairShare(byteDemands, h.byteLimit)
+
+	if h.dropped > 0 && now.Sub(h.lastReport) >= defaultRateLimitReportInterval*time.Second {
+		logWarn("Host rate limit dropped lines", "lines", h.dropped)
+		h.dropped = 0
+		h.lastReport = now
+	}
+
+	h.windowStart = now
+	h.lines = 0
+	h.bytes = 0
+	h.usage = make(map[string]*containerUsage)
+}
+
+// fairShare returns the max-min fair cap for the given demands sharing limit.
+// Containers demanding less than the cap are fully served; the rest are capped.
+// When every demand fits, only the overall limit applies.
+func fairShare(demands []float64, limit float64) float64 {
+	if limit <= 0 || len(demands) == 0 {
+		return math.Inf(1)
+	}
+	sort.Float64s(demands)
+	remaining := limit
+	for i, demand := range demands {
+		share := remaining / float64(len(demands)-i)
+		if demand > share {
+			return share
+		}
+		remaining -= demand
+	}
+	return limit
+}
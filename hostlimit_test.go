@@ -0,0 +1,92 @@
+package fluentd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostLimiter(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	type line struct {
+		container string
+		size      int
+		offset    time.Duration
+		want      bool
+	}
+	tests := []struct {
+		name   string
+		config HostRateLimitConfig
+		lines  []line
+	}{
+		{
+			name:  "disabled",
+			lines: []line{{"a", 10, 0, true}, {"a", 10, 0, true}},
+		},
+		{
+			name:   "line ceiling",
+			config: HostRateLimitConfig{Lines: 2},
+			lines:  []line{{"a", 1, 0, true}, {"b", 1, 0, true}, {"a", 1, 0, false}},
+		},
+		{
+			name:   "byte ceiling",
+			config: HostRateLimitConfig{Bytes: 10},
+			lines:  []line{{"a", 6, 0, true}, {"b", 6, 0, false}, {"b", 4, 0, true}},
+		},
+		{
+			name:   "new window",
+			config: HostRateLimitConfig{Lines: 1},
+			lines:  []line{{"a", 1, 0, true}, {"a", 1, 0, false}, {"a", 1, time.Second, true}},
+		},
+		{
+			// After a window in which a demanded 4 lines and b 1, b keeps its
+			// line and a is capped at the remaining 3
+			name:   "fair share",
+			config: HostRateLimitConfig{Lines: 4},
+			lines: []line{
+				{"a", 1, 0, true}, {"a", 1, 0, true}, {"a", 1, 0, true}, {"a", 1, 0, true}, {"b", 1, 0, false},
+				{"b", 1, time.Second, true},
+				{"a", 1, time.Second, true}, {"a", 1, time.Second, true}, {"a", 1, time.Second, true},
+				{"a", 1, time.Second, false},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHostLimiter(test.config)
+			for i, line := range test.lines {
+				if got := h.allow(line.container, line.size, start.Add(line.offset)); got != line.want {
+					t.Errorf("line %d: allow = %v, want %v", i, got, line.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFairShare(t *testing.T) {
+	tests := []struct {
+		name    string
+		demands []float64
+		limit   float64
+		want    float64
+	}{
+		{name: "all fit", demands: []float64{1, 2}, limit: 10, want: 10},
+		{name: "one capped", demands: []float64{1, 8}, limit: 5, want: 4},
+		{name: "even split", demands: []float64{8, 8}, limit: 10, want: 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := fairShare(test.demands, test.limit); got != test.want {
+				t.Errorf("fair share = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHostLimiterShared(t *testing.T) {
+	// Routes draw from the same host-wide capacity
+	first := sharedHostLimiter("first:24224", HostRateLimitConfig{Lines: 10})
+	second := sharedHostLimiter("second:24224", HostRateLimitConfig{Lines: 10})
+	if first != second {
+		t.Error("routes got their own host limiters")
+	}
+}
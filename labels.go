@@ -0,0 +1,209 @@
+package fluentd
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	defaultLabelRefreshInterval = 30
+	defaultExcludeLabel         = "fluentd.exclude"
+)
+
+// containerMeta is an immutable snapshot of the metadata messages of a
+// container are handled with. It is built when the container is first seen
+// and replaced as a whole when its labels change, so the per-message work of
+// looking up and combining container settings is done once per container.
+//
+// Strings taken from the container, its ID and name, are shared by all its
+// records. The tag is computed once per snapshot and tagging rules, so every
+// record of the container references the same tag string.
+type containerMeta struct {
+	image     string
+	digest    string
+	tagSuffix string
+	labels    map[string]string
+	options   *containerOptions
+
+	// cachedTag holds the *metaTag last computed by tag.
+	cachedTag atomic.Value
+}
+
+// metaTag is a tag computed for a tag prefix and suffix label.
+type metaTag struct {
+	prefix      string
+	suffixLabel string
+	tag         string
+}
+
+// newContainerMeta builds the metadata snapshot of a container.
+func newContainerMeta(container *docker.Container, source *optionSource) *containerMeta {
+	image, labels := container.Config.Image, container.Config.Labels
+	return &containerMeta{
+		image:     image,
+		digest:    imageDigest(image, container.Image),
+		tagSuffix: container.Name + "-" + container.Config.Hostname,
+		labels:    labels,
+		options:   source.options(image, labels),
+	}
+}
+
+// withLabels returns a copy of the snapshot with the given labels and the
+// options resolved from them.
+func (m *containerMeta) withLabels(labels map[string]string, source *optionSource) *containerMeta {
+	return &containerMeta{
+		image:     m.image,
+		digest:    m.digest,
+		tagSuffix: m.tagSuffix,
+		labels:    labels,
+		options:   source.options(m.image, labels),
+	}
+}
+
+// tag returns the tag of the container's records: the prefix and the value of
+// the suffix label, or the container's name and hostname without it. The tag
+// option of the container overrides both.
+func (m *containerMeta) tag(prefix, suffixLabel string) string {
+	if cached, ok := m.cachedTag.Load().(*metaTag); ok && cached.prefix == prefix &&
+		cached.suffixLabel == suffixLabel {
+		return cached.tag
+	}
+
+	tag := m.options.tag
+	if tag == "" {
+		tagSuffix := m.labels[suffixLabel]
+		if tagSuffix == "" {
+			tagSuffix = m.tagSuffix
+		}
+		tag = prefix + "." + tagSuffix
+	}
+	m.cachedTag.Store(&metaTag{prefix: prefix, suffixLabel: suffixLabel, tag: tag})
+	return tag
+}
+
+// labelEntry holds the latest metadata snapshot of a container.
+type labelEntry struct {
+	meta       *containerMeta
+	refreshed  time.Time
+	refreshing bool
+	lastSeen   time.Time
+}
+
+// labelCache keeps container metadata, most importantly labels, up to date
+// while containers are streaming. Messages carry the labels from when the
+// container was attached, so label based rules such as the exclude
+// kill-switch would otherwise only change after a logspout restart. Labels are
+// re-inspected in the background every LABEL_REFRESH_INTERVAL seconds; zero
+// disables refreshing and the docker client. Snapshots are dropped when a
+// container is removed or renamed, and otherwise when it has not logged for a
+// while.
+type labelCache struct {
+	client    *docker.Client
+	close     func()
+	interval  time.Duration
+	source    *optionSource
+	mu        sync.Mutex
+	entries   map[string]*labelEntry
+	lastSweep time.Time
+}
+
+// newLabelCache creates a label cache refreshing labels every interval and
+// resolving container options from the given source.
+func newLabelCache(interval time.Duration, source *optionSource) *labelCache {
+	cache := &labelCache{
+		interval: interval,
+		source:   source,
+		entries:  make(map[string]*labelEntry),
+	}
+	cache.close = func() {}
+	if interval <= 0 {
+		return cache
+	}
+	cache.client, cache.close = sharedDocker.subscribe(cache.event)
+	if cache.client == nil {
+		logWarn("Label refresh disabled without a docker client")
+	}
+	return cache
+}
+
+// meta returns the current metadata snapshot of a container. When its labels
+// are stale, a refresh is started in the background and the cached snapshot
+// is returned.
+func (c *labelCache) meta(container *docker.Container) *containerMeta {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(now)
+	entry, found := c.entries[container.ID]
+	if !found {
+		entry = &labelEntry{meta: newContainerMeta(container, c.source), refreshed: now}
+		c.entries[container.ID] = entry
+	}
+	entry.lastSeen = now
+	if c.client != nil && c.interval > 0 && !entry.refreshing && now.Sub(entry.refreshed) >= c.interval {
+		entry.refreshing = true
+		go c.refresh(container.ID, entry)
+	}
+	return entry.meta
+}
+
+// event drops the snapshots of removed and renamed containers.
+func (c *labelCache) event(event *docker.APIEvents) {
+	if event.Action != "destroy" && event.Action != "rename" {
+		return
+	}
+	id := event.Actor.ID
+	if id == "" {
+		id = event.ID
+	}
+	c.mu.Lock()
+	delete(c.entries, id)
+	c.mu.Unlock()
+}
+
+// reconfigure replaces the source of container options and resolves the
+// options of known containers again.
+func (c *labelCache) reconfigure(source *optionSource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.source = source
+	for _, entry := range c.entries {
+		entry.meta = entry.meta.withLabels(entry.meta.labels, source)
+	}
+}
+
+// refresh inspects the container and stores its current labels.
+func (c *labelCache) refresh(id string, entry *labelEntry) {
+	inspected, err := c.client.InspectContainerWithOptions(docker.InspectContainerOptions{ID: id})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.refreshing = false
+	entry.refreshed = time.Now()
+	if err != nil {
+		logDebug("Unable to refresh container labels", "container_id", id, "error", err)
+		return
+	}
+	if inspected.Config != nil {
+		entry.meta = entry.meta.withLabels(inspected.Config.Labels, c.source)
+	}
+}
+
+// sweep forgets containers that have not logged for a while.
+func (c *labelCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < bucketIdleTimeout {
+		return
+	}
+	c.lastSweep = now
+	for id, entry := range c.entries {
+		if !entry.refreshing && now.Sub(entry.lastSeen) >= bucketIdleTimeout {
+			delete(c.entries, id)
+		}
+	}
+}
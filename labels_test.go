@@ -0,0 +1,121 @@
+package fluentd
+
+import (
+	"testing"
+	"testing/quick"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// tagCase is a random container and tag configuration for the tag
+// properties.
+type tagCase struct {
+	Prefix     string
+	Name       string
+	Hostname   string
+	SuffixSet  bool
+	Suffix     string
+	OptionSet  bool
+	Option     string
+	OtherLabel string
+}
+
+// meta returns the metadata snapshot of the case's container.
+func (c tagCase) meta() *containerMeta {
+	labels := map[string]string{"other": c.OtherLabel}
+	if c.SuffixSet {
+		labels["service"] = c.Suffix
+	}
+	if c.OptionSet {
+		labels[defaultOptionLabelPrefix+"tag"] = c.Option
+	}
+	container := &docker.Container{
+		ID:     "id",
+		Name:   c.Name,
+		Config: &docker.Config{Hostname: c.Hostname, Labels: labels},
+	}
+	return newContainerMeta(container, &optionSource{prefix: defaultOptionLabelPrefix})
+}
+
+// want returns the documented tag of the case.
+func (c tagCase) want() string {
+	switch {
+	case c.OptionSet && c.Option != "":
+		return c.Option
+	case c.SuffixSet && c.Suffix != "":
+		return c.Prefix + "." + c.Suffix
+	}
+	return c.Prefix + "." + c.Name + "-" + c.Hostname
+}
+
+func TestTagProperties(t *testing.T) {
+	properties := map[string]func(tagCase) bool{
+		// The container's tag option wins, then the suffix label, then the
+		// container name and hostname
+		"precedence": func(c tagCase) bool {
+			return c.meta().tag(c.Prefix, "service") == c.want()
+		},
+		"never empty": func(c tagCase) bool {
+			return c.meta().tag(c.Prefix, "service") != ""
+		},
+		// Repeated and cached lookups return the same tag
+		"stable": func(c tagCase) bool {
+			meta := c.meta()
+			first := meta.tag(c.Prefix, "service")
+			return meta.tag(c.Prefix, "service") == first && c.meta().tag(c.Prefix, "service") == first
+		},
+		// A cached tag is not returned for another prefix or suffix label
+		"cache keyed by settings": func(c tagCase) bool {
+			meta := c.meta()
+			meta.tag(c.Prefix, "service")
+			other := c
+			other.Prefix += "x"
+			if meta.tag(other.Prefix, "service") != other.want() {
+				return false
+			}
+			unlabeled := c
+			unlabeled.SuffixSet = false
+			return meta.tag(c.Prefix, "missing") == unlabeled.want()
+		},
+		// Other labels don't affect the tag
+		"ignores other labels": func(c tagCase) bool {
+			other := c
+			other.OtherLabel += "x"
+			return c.meta().tag(c.Prefix, "service") == other.meta().tag(c.Prefix, "service")
+		},
+		// Without a tag option, the tag starts with the prefix
+		"prefixed": func(c tagCase) bool {
+			c.OptionSet = false
+			tag := c.meta().tag(c.Prefix, "service")
+			return len(tag) > len(c.Prefix) && tag[:len(c.Prefix)+1] == c.Prefix+"."
+		},
+	}
+	for name, property := range properties {
+		t.Run(name, func(t *testing.T) {
+			if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestTagOverrides(t *testing.T) {
+	// Empty values fall back instead of producing an empty or dangling tag
+	tests := []struct {
+		name string
+		c    tagCase
+		want string
+	}{
+		{name: "empty option", c: tagCase{Prefix: "docker", Name: "web", Hostname: "h", OptionSet: true},
+			want: "docker.web-h"},
+		{name: "empty suffix", c: tagCase{Prefix: "docker", Name: "web", Hostname: "h", SuffixSet: true},
+			want: "docker.web-h"},
+		{name: "option over suffix", c: tagCase{Prefix: "docker", SuffixSet: true, Suffix: "checkout",
+			OptionSet: true, Option: "custom"}, want: "custom"},
+	}
+	for _, test := range tests {
+		if got := test.c.meta().tag(test.c.Prefix, "service"); got != test.want {
+			t.Errorf("%s: tag = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package fluentd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Levels of the adapter's own log lines.
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// logger writes the adapter's own log lines with a level and key-value fields.
+// By default lines are logfmt-style text through the standard logger; with
+// LOG_FORMAT=json each line is a JSON object on stderr. LOG_LEVEL sets the
+// minimum level, and DEBUG=true is still accepted for LOG_LEVEL=debug.
+type logger struct {
+	mu    sync.Mutex
+	level int
+	json  bool
+}
+
+var adapterLog = newLogger()
+
+// newLogger creates a logger configured by the environment.
+func newLogger() *logger {
+	l := &logger{
+		level: levelInfo,
+		json:  getenv("LOG_FORMAT", "text") == "json",
+	}
+	if os.Getenv("DEBUG") == "true" {
+		l.level = levelDebug
+	}
+	if name := getenv("LOG_LEVEL", ""); name != "" {
+		for level, levelName := range levelNames {
+			if strings.EqualFold(name, levelName) {
+				l.level = level
+			}
+		}
+	}
+	return l
+}
+
+func logDebug(msg string, fields ...interface{}) { adapterLog.log(levelDebug, msg, fields) }
+func logInfo(msg string, fields ...interface{})  { adapterLog.log(levelInfo, msg, fields) }
+func logWarn(msg string, fields ...interface{})  { adapterLog.log(levelWarn, msg, fields) }
+func logError(msg string, fields ...interface{}) { adapterLog.log(levelError, msg, fields) }
+
+// logFatal logs an error and exits.
+func logFatal(msg string, fields ...interface{}) {
+	adapterLog.log(levelError, msg, fields)
+	os.Exit(1)
+}
+
+// debugEnabled reports whether debug lines are logged, to skip building
+// expensive fields otherwise.
+func debugEnabled() bool {
+	return adapterLog.level <= levelDebug
+}
+
+// log writes a line with the given fields, given as alternating keys and
+// values, when the level is enabled.
+func (l *logger) log(level int, msg string, fields []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	if l.json {
+		entry := map[string]interface{}{
+			"time":  time.Now().UTC().Format(time.RFC3339Nano),
+			"level": levelNames[level],
+			"msg":   msg,
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			entry[fmt.Sprint(fields[i])] = fieldValue(fields[i+1])
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			data = []byte(strconv.Quote(msg))
+		}
+		l.mu.Lock()
+		os.Stderr.Write(append(data, '\n'))
+		l.mu.Unlock()
+		return
+	}
+
+	var line strings.Builder
+	line.WriteString("fluentd-adapter level=" + levelNames[level] + " msg=" + strconv.Quote(msg))
+	for i := 0; i+1 < len(fields); i += 2 {
+		line.WriteString(" " + fmt.Sprint(fields[i]) + "=" + textValue(fieldValue(fields[i+1])))
+	}
+	log.Println(line.String())
+}
+
+// fieldValue converts a field value into a value that marshals well.
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// textValue formats a field value for text lines, quoting it when needed.
+func textValue(value interface{}) string {
+	text := fmt.Sprint(value)
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		return strconv.Quote(text)
+	}
+	return text
+}
@@ -0,0 +1,102 @@
+package fluentd
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultErrorLogInterval = 10 * time.Second
+
+// errorLogSampler limits repeated error lines, such as one per failed post
+// while fluentd is down, to one per key and interval. Suppressed lines are
+// counted and summarized once per interval instead. The summarizing goroutine
+// only runs while there are errors to track, so an idle adapter doesn't wake.
+type errorLogSampler struct {
+	mu       sync.Mutex
+	interval time.Duration
+	keys     map[string]*sampledError
+	running  bool
+}
+
+type sampledError struct {
+	last       time.Time
+	suppressed int
+}
+
+var errorLog = &errorLogSampler{
+	interval: errorLogInterval(),
+	keys:     make(map[string]*sampledError),
+}
+
+// errorLogInterval reads ERROR_LOG_INTERVAL; zero logs every error.
+func errorLogInterval() time.Duration {
+	value := getenv("ERROR_LOG_INTERVAL", "")
+	if value == "" {
+		return defaultErrorLogInterval
+	}
+	interval, err := parseDuration(value, time.Second)
+	if err != nil || interval < 0 {
+		logWarn("Invalid ERROR_LOG_INTERVAL, using the default", "value", value)
+		return defaultErrorLogInterval
+	}
+	return interval
+}
+
+// logSampledError logs an error unless one with the same key was logged
+// within the interval.
+func logSampledError(key, msg string, fields ...interface{}) {
+	if errorLog.allow(key, time.Now()) {
+		logError(msg, fields...)
+	}
+}
+
+// allow reports whether an error with the given key may be logged now.
+func (s *errorLogSampler) allow(key string, now time.Time) bool {
+	if s.interval <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+
+	entry, found := s.keys[key]
+	if !found {
+		s.keys[key] = &sampledError{last: now}
+		return true
+	}
+	if now.Sub(entry.last) >= s.interval && entry.suppressed == 0 {
+		entry.last = now
+		return true
+	}
+	entry.suppressed++
+	return false
+}
+
+// run summarizes suppressed errors every interval, until all errors expired.
+func (s *errorLogSampler) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		for key, entry := range s.keys {
+			if entry.suppressed > 0 {
+				logWarn("Errors suppressed", "key", key, "count", entry.suppressed, "interval", s.interval)
+				entry.suppressed = 0
+				entry.last = now
+			} else if now.Sub(entry.last) >= s.interval {
+				delete(s.keys, key)
+			}
+		}
+		if len(s.keys) == 0 {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
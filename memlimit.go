@@ -0,0 +1,83 @@
+package fluentd
+
+import (
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultBufferMemoryPercent = 25
+
+	// maxRecordBytes is the size assumed of every buffered record, that of
+	// the longest log line before Docker splits it.
+	maxRecordBytes = 16 * 1024
+)
+
+// cgroupMemoryLimits are the files holding the container's memory limit, for
+// cgroup v2 and v1.
+var cgroupMemoryLimits = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// memoryLimit returns the memory available to the process: GOMEMLIMIT when
+// set, otherwise the limit of the container's cgroup. It returns 0 when there
+// is no limit.
+func memoryLimit() int64 {
+	// A negative limit only reads the current one
+	if limit := debug.SetMemoryLimit(-1); limit > 0 && limit < math.MaxInt64 {
+		return limit
+	}
+	for _, path := range cgroupMemoryLimits {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		// cgroup v1 reports a huge number rather than "max" without a limit
+		if err == nil && limit > 0 && limit < 1<<60 {
+			return limit
+		}
+	}
+	return 0
+}
+
+// sizeBuffers sizes the buffers of the fluent loggers as a share of the
+// memory limit, unless FLUENTD_BUFFER_LIMIT is set. The fluent logger limits
+// its buffer by records, so BUFFER_MEMORY_PERCENT of the limit is split evenly
+// among the route's writers and divided by maxRecordBytes. A backlog then
+// fills the buffers and is reported as dropped instead of getting logspout
+// OOM-killed.
+func (l *configLoader) sizeBuffers(config *Config) {
+	percent := l.int("buffer_memory_percent", "BUFFER_MEMORY_PERCENT", defaultBufferMemoryPercent, 0)
+	if percent == 0 || l.sources["buffer_limit"] != sourceDefault {
+		return
+	}
+	config.MemoryLimit = memoryLimit()
+	if config.MemoryLimit == 0 {
+		return
+	}
+
+	writers := []*WriterConfig{&config.Writer}
+	for i := range config.Destinations {
+		writers = append(writers, &config.Destinations[i].Writer)
+	}
+	if config.Mirror != nil {
+		writers = append(writers, &config.Mirror.Writer)
+	}
+	limit := config.MemoryLimit * int64(percent) / 100 / int64(len(writers)) / maxRecordBytes
+	if limit < 1 {
+		limit = 1
+	} else if limit > math.MaxInt32 {
+		limit = math.MaxInt32
+	}
+	for _, writer := range writers {
+		// Sub-writers with an explicit buffer_limit keep it
+		if writer.BufferLimit == defaultBufferLimit {
+			writer.BufferLimit = int(limit)
+		}
+	}
+}
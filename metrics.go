@@ -0,0 +1,246 @@
+package fluentd
+
+import (
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "fluentd_adapter"
+
+// Metrics of all routes, labeled by the route's fluentd address. They are
+// always collected and served on METRICS_ADDRESS when it is set. The same
+// counters may be published with expvar, see expvar.go.
+var (
+	recordsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "records_received_total",
+		Help:      "Log lines received from containers.",
+	}, []string{"route"})
+	recordsForwarded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "records_forwarded_total",
+		Help:      "Records posted to fluentd, including reports.",
+	}, []string{"route"})
+	recordsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "records_dropped_total",
+		Help:      "Records discarded, by reason.",
+	}, []string{"route", "reason"})
+	bytesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bytes_sent_total",
+		Help:      "Bytes of log lines posted to fluentd.",
+	}, []string{"route"})
+	postErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "post_errors_total",
+		Help:      "Failed posts to fluentd, by destination and error class.",
+	}, []string{"route", "destination", "type"})
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "mirror_queue_depth",
+		Help:      "Records waiting in the mirror queue.",
+	}, []string{"route"})
+	connected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "connected",
+		Help:      "Whether the last post to the route's fluentd succeeded.",
+	}, []string{"route"})
+	postDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "post_duration_seconds",
+		Help:      "Time spent in PostWithTime, by destination.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"route", "destination"})
+	forwardLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "forward_latency_seconds",
+		Help:      "Time from a container writing a line to posting its record.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"route"})
+	recordSize = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace:  metricsNamespace,
+		Name:       "record_size_bytes",
+		Help:       "Size of the log line of posted records.",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	}, []string{"route"})
+)
+
+func init() {
+	prometheus.MustRegister(recordsReceived, recordsForwarded, recordsDropped, bytesSent, postErrors,
+		queueDepth, connected, postDuration, forwardLatency, recordSize)
+}
+
+// deleteRouteSeries deletes the series of a closed route from every metric
+// labeled by route, so routes that come and go don't accumulate series.
+func deleteRouteSeries(route string) {
+	for _, vec := range []interface{ DeleteLabelValues(...string) bool }{recordsReceived, recordsForwarded,
+		bytesSent, queueDepth, connected, forwardLatency, recordSize, writerRestarts} {
+		vec.DeleteLabelValues(route)
+	}
+	for _, name := range stateNames {
+		adapterState.DeleteLabelValues(route, name)
+	}
+	labels := prometheus.Labels{"route": route}
+	for _, vec := range []interface{ DeletePartialMatch(prometheus.Labels) int }{recordsDropped, postErrors,
+		postDuration, connectionEventsTotal, queueHighWater, queueWarnings, tagRecords, tagBytes} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// routeMetrics are the metrics of a single route.
+type routeMetrics struct {
+	route     string
+	received  prometheus.Counter
+	forwarded prometheus.Counter
+	bytes     prometheus.Counter
+	queue     prometheus.Gauge
+	connected prometheus.Gauge
+	size      prometheus.Observer
+	latency   prometheus.Observer
+	vars      *routeVars
+	health    *routeHealth
+
+	// durations and drops cache the children of postDuration by destination
+	// and of recordsDropped by reason, which are looked up for every record.
+	durations sync.Map
+	drops     sync.Map
+}
+
+// newRouteMetrics creates the metrics of the route to the given address.
+func newRouteMetrics(route string) *routeMetrics {
+	return &routeMetrics{
+		route:     route,
+		received:  recordsReceived.WithLabelValues(route),
+		forwarded: recordsForwarded.WithLabelValues(route),
+		bytes:     bytesSent.WithLabelValues(route),
+		queue:     queueDepth.WithLabelValues(route),
+		connected: connected.WithLabelValues(route),
+		size:      recordSize.WithLabelValues(route),
+		latency:   forwardLatency.WithLabelValues(route),
+		vars:      newRouteVars(),
+		health:    &routeHealth{route: route},
+	}
+}
+
+// receive counts a log line received from a container.
+func (m *routeMetrics) receive() {
+	m.received.Inc()
+	m.vars.counters.Add("records_received", 1)
+}
+
+// dropped counts a record discarded for the given reason.
+func (m *routeMetrics) dropped(reason string) {
+	drops, found := m.drops.Load(reason)
+	if !found {
+		drops, _ = m.drops.LoadOrStore(reason, recordsDropped.WithLabelValues(m.route, reason))
+	}
+	drops.(prometheus.Counter).Inc()
+	m.vars.counters.Add("records_dropped_"+reason, 1)
+}
+
+// delayed records the time from a container writing a line to posting it.
+func (m *routeMetrics) delayed(latency time.Duration) {
+	m.latency.Observe(latency.Seconds())
+}
+
+// queued records the depth of the mirror queue.
+func (m *routeMetrics) queued(depth int) {
+	m.queue.Set(float64(depth))
+	m.vars.queue.Set(int64(depth))
+}
+
+// mirrored accounts the result of posting a record to the mirror.
+func (m *routeMetrics) mirrored(took time.Duration, err error) {
+	m.duration("mirror").Observe(took.Seconds())
+	if err != nil {
+		postErrors.WithLabelValues(m.route, "mirror", errorType(err)).Inc()
+		m.vars.counters.Add("post_errors_mirror_"+errorType(err), 1)
+	}
+}
+
+// posted accounts the result of posting a record of the given size to the
+// named destination, see destinationName, which took the given time.
+func (m *routeMetrics) posted(destination string, size int, took time.Duration, err error) {
+	m.duration(destination).Observe(took.Seconds())
+	if err != nil {
+		postErrors.WithLabelValues(m.route, destination, errorType(err)).Inc()
+		m.vars.counters.Add("post_errors_"+destination+"_"+errorType(err), 1)
+		return
+	}
+	m.forwarded.Inc()
+	m.bytes.Add(float64(size))
+	m.size.Observe(float64(size))
+	m.vars.counters.Add("records_forwarded", 1)
+	m.vars.counters.Add("bytes_sent", int64(size))
+}
+
+// connection accounts the outcome of writing to the route's own fluentd.
+func (m *routeMetrics) connection(err error) {
+	if err != nil {
+		m.connected.Set(0)
+		m.vars.connected.Set(0)
+		m.health.failed(time.Now())
+		return
+	}
+	m.connected.Set(1)
+	m.vars.connected.Set(1)
+	m.health.succeeded()
+}
+
+// duration returns the post duration histogram of a destination.
+func (m *routeMetrics) duration(destination string) prometheus.Observer {
+	duration, found := m.durations.Load(destination)
+	if !found {
+		duration, _ = m.durations.LoadOrStore(destination, postDuration.WithLabelValues(m.route, destination))
+	}
+	return duration.(prometheus.Observer)
+}
+
+var (
+	metricsServersMu sync.Mutex
+	metricsServers   = make(map[string]*healthChecks)
+)
+
+// serveMetrics serves /metrics, /debug/vars and the health endpoints of all
+// routes configured with the same address, starting the server on first use.
+func serveMetrics(address string, health *routeHealth) {
+	if address == "" {
+		return
+	}
+
+	metricsServersMu.Lock()
+	defer metricsServersMu.Unlock()
+
+	if checks, found := metricsServers[address]; found {
+		checks.add(health)
+		return
+	}
+	checks := &healthChecks{}
+	checks.add(health)
+	metricsServers[address] = checks
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/ready", checks.ready)
+	go func() {
+		err := http.ListenAndServe(address, mux)
+		logError("Metrics server stopped", "address", address, "error", err)
+	}()
+}
+
+// unserveMetrics removes the health of a closed route from the health
+// endpoints at address. The server keeps serving the other routes.
+func unserveMetrics(address string, health *routeHealth) {
+	metricsServersMu.Lock()
+	defer metricsServersMu.Unlock()
+	if checks, found := metricsServers[address]; found {
+		checks.remove(health)
+	}
+}
@@ -0,0 +1,124 @@
+package fluentd
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultMirrorQueueSize = 1024
+
+// queuedRecord is a record queued for the mirror or a destination.
+type queuedRecord struct {
+	tag    string
+	time   time.Time
+	record map[string]string
+}
+
+// mirror duplicates records to a secondary fluentd destination. Records are
+// handed over through a bounded queue and posted from their own goroutine, so
+// a slow or failing mirror drops its own records instead of delaying the
+// primary destination.
+type mirror struct {
+	writer     Poster
+	tagPattern *regexp.Regexp
+	queue      chan queuedRecord
+	audit      *dropAudit
+	metrics    *routeMetrics
+	watch      *queueWatch
+	encoder    recordEncoder
+	done       chan struct{}
+}
+
+// newMirror creates a mirror from the mirror configuration. It returns nil
+// when no mirror is configured.
+func newMirror(config *MirrorConfig, audit *dropAudit, metrics *routeMetrics,
+	encoder recordEncoder) (*mirror, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	var tagPattern *regexp.Regexp
+	if config.TagPattern != "" {
+		var err error
+		if tagPattern, err = regexp.Compile(config.TagPattern); err != nil {
+			return nil, errors.Wrapf(err, "Invalid FLUENTD_MIRROR_TAG_PATTERN")
+		}
+	}
+
+	writer, err := newWriter(config.Writer, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to create fluentd mirror")
+	}
+
+	m := &mirror{
+		writer:     writer,
+		tagPattern: tagPattern,
+		queue:      make(chan queuedRecord, config.QueueSize),
+		audit:      audit,
+		metrics:    metrics,
+		encoder:    encoder,
+		done:       make(chan struct{}),
+		watch:      newQueueWatch(metrics.route, "mirror", config.Writer.Address, config.QueueSize, config.QueueWarn),
+	}
+	go m.run()
+	return m, nil
+}
+
+// send queues a copy of the record for the mirror if its tag matches. It never
+// blocks; records are dropped when the mirror queue is full.
+func (m *mirror) send(tag string, t time.Time, record map[string]string) {
+	if m == nil || (m.tagPattern != nil && !m.tagPattern.MatchString(tag)) {
+		return
+	}
+	copied := make(map[string]string, len(record))
+	for key, value := range record {
+		copied[key] = value
+	}
+	select {
+	case m.queue <- queuedRecord{tag: tag, time: t, record: copied}:
+		m.metrics.queued(len(m.queue))
+		m.watch.observe(len(m.queue))
+	default:
+		logDebug("Mirror queue full, dropping record", "tag", tag)
+		m.audit.drop(dropMirrorOverflow)
+	}
+}
+
+// pending returns the number of records queued for the mirror.
+func (m *mirror) pending() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.queue)
+}
+
+// close stops posting queued records. Records still queued are dropped.
+func (m *mirror) close() {
+	if m != nil {
+		close(m.done)
+	}
+}
+
+// run posts queued records to the mirror destination until it is closed.
+func (m *mirror) run() {
+	for {
+		var r queuedRecord
+		select {
+		case r = <-m.queue:
+		case <-m.done:
+			return
+		}
+		m.metrics.queued(len(m.queue))
+		m.watch.observe(len(m.queue))
+		start := time.Now()
+		err := m.writer.PostWithTime(r.tag, r.time, m.encoder.encode(r.record))
+		m.metrics.mirrored(time.Since(start), err)
+		if err != nil {
+			class := errorType(err)
+			logSampledError("mirror:"+class, "Mirror PostWithTime failed", "error", err, "class", class,
+				"tag", r.tag)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package fluentd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMirrorOverflow(t *testing.T) {
+	fluentd := newFakeFluentd(t, nil, 50*time.Millisecond)
+	primary := &fakePoster{}
+	ad := newTestAdapter(t, map[string]string{
+		// Post synchronously so the mirror is as slow as its fluentd
+		"mirror_address":         fluentd.address() + "?async=false&request_ack=true",
+		"mirror_queue_size":      "2",
+		"label_refresh_interval": "0",
+	}, primary)
+
+	for _, message := range lineMessages(20) {
+		ad.handle(ad.ctx, message)
+	}
+	// The primary destination is not held up by the mirror
+	if posts := len(primary.received()); posts != 20 {
+		t.Errorf("primary received %d of 20 lines", posts)
+	}
+	checkOverflow(t, ad, fluentd, 20, dropMirrorOverflow, 2)
+}
+
+func TestMirrorTagPattern(t *testing.T) {
+	fluentd := newFakeFluentd(t, nil, 0)
+	ad := newTestAdapter(t, map[string]string{
+		"mirror_address":         fluentd.address(),
+		"mirror_tag_pattern":     `^docker\.api-`,
+		"label_refresh_interval": "0",
+	}, &fakePoster{})
+
+	ad.handle(ad.ctx, testMessage("web", "skipped", nil))
+	ad.handle(ad.ctx, testMessage("api", "mirrored", nil))
+	received := fluentd.wait(t, 1)
+	if len(received) != 1 || received[0].record["log"] != "mirrored" || received[0].tag != "docker.api-host" {
+		t.Errorf("mirror received %v, want only the api line", received)
+	}
+}
@@ -0,0 +1,89 @@
+package fluentd
+
+// flatRecord is a record of string fields that encodes itself as msgpack.
+// The fluent logger posts records implementing msgp.Marshaler as they are,
+// instead of copying maps into a map[string]interface{} by reflection and
+// encoding that generically.
+//
+// Log lines stay the strings logspout read until they are encoded: records,
+// the mirror queue and reports share them without copying, so a large line is
+// copied once, into the encoded message. As the fluent logger sizes its buffer
+// by Msgsize, that copy also happens without growing the buffer.
+type flatRecord map[string]string
+
+// Msgsize returns the exact size of the encoded record.
+func (r flatRecord) Msgsize() int {
+	size := mapHeaderSize(len(r))
+	for key, value := range r {
+		size += stringSize(key) + stringSize(value)
+	}
+	return size
+}
+
+// MarshalMsg appends the record as a msgpack map to b.
+func (r flatRecord) MarshalMsg(b []byte) ([]byte, error) {
+	if size := r.Msgsize(); cap(b)-len(b) < size {
+		grown := make([]byte, len(b), len(b)+size)
+		copy(grown, b)
+		b = grown
+	}
+
+	b = appendMapHeader(b, len(r))
+	for key, value := range r {
+		b = appendString(b, key)
+		b = appendString(b, value)
+	}
+	return b, nil
+}
+
+// mapHeaderSize returns the size of the header of a map with n entries.
+func mapHeaderSize(n int) int {
+	switch {
+	case n < 16:
+		return 1
+	case n <= 0xffff:
+		return 3
+	}
+	return 5
+}
+
+// stringSize returns the encoded size of a str.
+func stringSize(s string) int {
+	switch n := len(s); {
+	case n < 32:
+		return 1 + n
+	case n <= 0xff:
+		return 2 + n
+	case n <= 0xffff:
+		return 3 + n
+	default:
+		return 5 + n
+	}
+}
+
+// appendMapHeader appends the header of a msgpack map with n entries.
+func appendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= 0xffff:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	default:
+		return append(b, 0xdf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// appendString appends a msgpack str.
+func appendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= 0xff:
+		b = append(b, 0xd9, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, s...)
+}
@@ -0,0 +1,123 @@
+package fluentd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// TestRecordEncoding checks that flatRecord encodes to msgpack the way the
+// fake fluentd, and so fluentd, decodes it.
+func TestRecordEncoding(t *testing.T) {
+	record := flatRecord{
+		"log":   strings.Repeat("x", 70000),
+		"short": "",
+		"mid":   strings.Repeat("y", 200),
+	}
+	for i := 0; i < 20; i++ {
+		record["field"+string(rune('a'+i))] = "value"
+	}
+	encoded, err := record.MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) != record.Msgsize() {
+		t.Errorf("encoded %d bytes, Msgsize is %d", len(encoded), record.Msgsize())
+	}
+	decoded, err := decodeRecord(encoded)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(decoded) != len(record) {
+		t.Fatalf("decoded %d fields, want %d", len(decoded), len(record))
+	}
+	for key, value := range record {
+		if decoded[key] != value {
+			t.Errorf("%s: decoded %d bytes, want %d", key, len(decoded[key]), len(value))
+		}
+	}
+}
+
+// decodeRecord decodes an encoded flatRecord.
+func decodeRecord(b []byte) (map[string]string, error) {
+	value, rest, err := msgp.ReadIntfBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing bytes")
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("not a map")
+	}
+	record := make(map[string]string, len(fields))
+	for key, value := range fields {
+		if record[key], ok = value.(string); !ok {
+			return nil, errors.New(key + " is not a string")
+		}
+	}
+	return record, nil
+}
+
+func FuzzFlatRecordMarshalMsg(f *testing.F) {
+	f.Add("log", "hello", 1)
+	f.Add("", "", 0)
+	f.Add("log", strings.Repeat("x", 40), 15)
+	f.Add("\xff\xfe", "\x00\xc1", 16)
+	f.Fuzz(func(t *testing.T, key, value string, fields int) {
+		// Cover every map header size without huge records
+		fields %= 70000
+		if fields < 0 {
+			fields = -fields
+		}
+		record := flatRecord{key: value}
+		for i := 0; i < fields; i++ {
+			record[key+strconv.Itoa(i)] = value
+		}
+		encoded, err := record.MarshalMsg([]byte{0xc0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(encoded) != 1+record.Msgsize() {
+			t.Fatalf("encoded %d bytes, Msgsize is %d", len(encoded)-1, record.Msgsize())
+		}
+		decoded, err := decodeRecord(encoded[1:])
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if len(decoded) != len(record) {
+			t.Fatalf("decoded %d fields, want %d", len(decoded), len(record))
+		}
+		for key, value := range record {
+			if decoded[key] != value {
+				t.Fatalf("%q: decoded %q, want %q", key, decoded[key], value)
+			}
+		}
+	})
+}
+
+func BenchmarkFlatRecordMarshalMsg(b *testing.B) {
+	lines := map[string]string{
+		"short": "2024-05-01T12:00:00Z INFO request served status=200",
+		"large": strings.Repeat("x", 16*1024),
+	}
+	for name, line := range lines {
+		record := flatRecord{
+			"log":            line,
+			"container_id":   "3f4e5d6c7b8a",
+			"container_name": "web",
+			"source":         "stdout",
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(record.Msgsize()))
+			for i := 0; i < b.N; i++ {
+				record.MarshalMsg(nil)
+			}
+		})
+	}
+}
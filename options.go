@@ -0,0 +1,122 @@
+package fluentd
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultOptionLabelPrefix = "fluentd.opt."
+
+// containerOptions are adapter settings overridden for a single container by
+// labels in the option namespace, e.g. fluentd.opt.sample_rate=10, or by the
+// option bundle of its image. They take precedence over the dedicated
+// per-container labels and the global settings. Unset values leave the
+// adapter setting in place.
+type containerOptions struct {
+	tag        string
+	sampleRate int
+	stream     string
+	exclude    bool
+
+	// errs are the options that failed to parse, see STRICT_MODE.
+	errs []string
+}
+
+// ImageOptionsConfig applies container options to every container whose image
+// matches a pattern such as "*/nginx*", using path.Match syntax.
+type ImageOptionsConfig struct {
+	Image   string            `yaml:"image"`
+	Options map[string]string `yaml:"options"`
+}
+
+// optionSource resolves the options of containers from their image and labels.
+type optionSource struct {
+	prefix string
+	images []ImageOptionsConfig
+}
+
+// newOptionSource creates the source of container options of a configuration.
+func newOptionSource(config *Config) *optionSource {
+	return &optionSource{
+		prefix: config.OptionLabelPrefix,
+		images: config.ImageOptions,
+	}
+}
+
+// options returns the options of a container. Bundles of all matching images
+// apply in order, then labels override them. Unknown keys and invalid values
+// are ignored and recorded in errs.
+func (s *optionSource) options(image string, labels map[string]string) *containerOptions {
+	options := &containerOptions{}
+	for _, bundle := range s.images {
+		if matched, _ := path.Match(bundle.Image, image); !matched {
+			continue
+		}
+		for name, value := range bundle.Options {
+			if err := options.set(name, value); err != nil {
+				logDebug("Invalid image option", "image", bundle.Image, "error", err)
+				options.errs = append(options.errs, "image "+bundle.Image+": "+err.Error())
+			}
+		}
+	}
+	if s.prefix == "" {
+		return options
+	}
+	for key, value := range labels {
+		if !strings.HasPrefix(key, s.prefix) {
+			continue
+		}
+		if err := options.set(strings.TrimPrefix(key, s.prefix), value); err != nil {
+			logDebug("Invalid container option label", "label", key, "error", err)
+			options.errs = append(options.errs, "label "+key+": "+err.Error())
+		}
+	}
+	return options
+}
+
+// set applies a single option by name.
+func (o *containerOptions) set(name, value string) error {
+	switch name {
+	case "tag":
+		o.tag = value
+	case "sample_rate":
+		rate, err := strconv.Atoi(value)
+		if err != nil || rate < 1 {
+			return errors.Errorf("%s=%q: must be a positive integer", name, value)
+		}
+		o.sampleRate = rate
+	case "stream":
+		if !validStream(value) {
+			return errors.Errorf("%s=%q: must be one of all, stdout, stderr", name, value)
+		}
+		o.stream = value
+	case "exclude":
+		exclude, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Errorf("%s=%q: must be true or false", name, value)
+		}
+		o.exclude = exclude
+	default:
+		return errors.Errorf("unknown option %s", name)
+	}
+	return nil
+}
+
+// validateImageOptions checks image patterns and their option bundles.
+func validateImageOptions(images []ImageOptionsConfig) error {
+	for _, bundle := range images {
+		if _, err := path.Match(bundle.Image, ""); err != nil {
+			return errors.Wrapf(err, "Invalid image pattern %s", bundle.Image)
+		}
+		options := &containerOptions{}
+		for name, value := range bundle.Options {
+			if err := options.set(name, value); err != nil {
+				return errors.Wrapf(err, "Invalid options for image %s", bundle.Image)
+			}
+		}
+	}
+	return nil
+}
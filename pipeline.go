@@ -0,0 +1,263 @@
+package fluentd
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// pipeline holds the components a message passes through once it was
+// received, each behind a small interface:
+//
+//	filters  decide whether a message is forwarded (filterChain)
+//	tags     resolve the tag of a container's records (tagResolver)
+//	records  build the record of a message (recordBuilder), by default
+//	         with a chain of middlewares
+//	encoder  turn a record into what is posted (recordEncoder)
+//
+// Records are posted by the transport of their destination, a Poster. A
+// component is replaced by setting it in newPipeline, without changing how
+// Adapter.handle drives them.
+type pipeline struct {
+	filters filterChain
+	tags    tagResolver
+	records recordBuilder
+	encoder recordEncoder
+
+	// wasm is the WASM filter among the middlewares, if any, released by
+	// close.
+	wasm *wasmFilter
+}
+
+// newPipeline creates the default pipeline with the record script and WASM
+// filter of the configuration, if any, and the registered extensions, see
+// RegisterFilter and RegisterEnricher.
+func newPipeline(config *Config) (*pipeline, error) {
+	script, err := newRecordScript(config.Script)
+	if err != nil {
+		return nil, err
+	}
+	wasm, err := newWasmFilter(config.Wasm)
+	if err != nil {
+		return nil, err
+	}
+	middlewares := enrichers
+	if script != nil {
+		middlewares = middlewares.use(script.middleware)
+	}
+	if wasm != nil {
+		middlewares = middlewares.use(wasm.middleware)
+	}
+	filters, records := withExtensions(defaultFilters, middlewares)
+	return &pipeline{
+		filters: filters,
+		tags:    containerTags{},
+		records: records,
+		encoder: msgpackEncoder{},
+		wasm:    wasm,
+	}, nil
+}
+
+// close releases the resources of the pipeline's components.
+func (p *pipeline) close() {
+	p.wasm.close()
+}
+
+// tagResolver computes the tag of the records of a container.
+type tagResolver interface {
+	tag(meta *containerMeta, rules *rules) string
+}
+
+// recordBuilder builds the record of a message that passed the filters, or
+// returns false to drop it. The record should come from containerRecord, as
+// it is released to recordPool once posted.
+type recordBuilder interface {
+	build(d *delivery) (map[string]string, bool)
+}
+
+// recordEncoder converts a record into the message handed to a Poster.
+type recordEncoder interface {
+	encode(record map[string]string) interface{}
+}
+
+// containerTags tags records with TAG_PREFIX and the TAG_SUFFIX_LABEL value,
+// or the container's name and hostname, unless the container's tag option
+// overrides it.
+type containerTags struct{}
+
+func (containerTags) tag(meta *containerMeta, rules *rules) string {
+	return meta.tag(rules.tagPrefix, rules.tagSuffixLabel)
+}
+
+// middleware processes the record of a message, returning the record to pass
+// on, usually the same map, or false to drop the message. Middlewares may
+// enrich, rewrite or redact fields; they must not keep the record, which is
+// reused once posted.
+type middleware func(d *delivery, record map[string]string) (map[string]string, bool)
+
+// middlewareChain builds the container record and runs its middlewares in the
+// order they were registered with use.
+type middlewareChain []middleware
+
+// use returns the chain with the given middlewares appended.
+func (c middlewareChain) use(middlewares ...middleware) middlewareChain {
+	return append(c[:len(c):len(c)], middlewares...)
+}
+
+func (c middlewareChain) build(d *delivery) (map[string]string, bool) {
+	record := containerRecord(d.message)
+	for _, next := range c {
+		var keep bool
+		if record, keep = next(d, record); !keep {
+			releaseRecord(record)
+			return nil, false
+		}
+	}
+	return record, true
+}
+
+// enrichers add the fields enabled by the rules to every record.
+var enrichers = middlewareChain{}.use(
+	sampleRateField,
+	imageDigestField,
+	versionField,
+	parseFailureFields,
+	truncateLog,
+	latencyField,
+)
+
+// sampleRateField records the rate a sampled message was kept at.
+func sampleRateField(d *delivery, record map[string]string) (map[string]string, bool) {
+	if d.sampleRate > 1 {
+		record["sample_rate"] = strconv.Itoa(d.sampleRate)
+	}
+	return record, true
+}
+
+// imageDigestField adds the image digest when IMAGE_DIGEST_FIELD is set.
+func imageDigestField(d *delivery, record map[string]string) (map[string]string, bool) {
+	if d.rules.digestFilter.field {
+		record["image_digest"] = d.meta.digest
+	}
+	return record, true
+}
+
+// versionField adds the forwarder version when VERSION_FIELD is set.
+func versionField(d *delivery, record map[string]string) (map[string]string, bool) {
+	if d.rules.versionField {
+		record["forwarder_version"] = Version
+	}
+	return record, true
+}
+
+// parseFailureFields annotates settings that failed to parse, see
+// STRICT_MODE.
+func parseFailureFields(d *delivery, record map[string]string) (map[string]string, bool) {
+	d.failures.annotate(d.rules.strictMode, record)
+	return record, true
+}
+
+// truncateLog shortens lines over MAX_LINE_BYTES.
+func truncateLog(d *delivery, record map[string]string) (map[string]string, bool) {
+	if max := d.rules.maxLineBytes; max > 0 && len(d.message.Data) > max {
+		record["log"] = truncateLine(d.message.Data, max)
+		record["truncated_from_bytes"] = strconv.Itoa(len(d.message.Data))
+	}
+	return record, true
+}
+
+// latencyField adds the forward latency when LATENCY_FIELD is set.
+func latencyField(d *delivery, record map[string]string) (map[string]string, bool) {
+	if d.rules.latencyField {
+		record["forward_latency_ms"] = strconv.FormatInt(int64(d.latency/time.Millisecond), 10)
+	}
+	return record, true
+}
+
+// msgpackEncoder encodes records with the msgpack fast path, see flatRecord.
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) encode(record map[string]string) interface{} {
+	return flatRecord(record)
+}
+
+// Costs of filter stages, by the kind of work they do per message.
+const (
+	costLookup     = iota // label and option lookups, comparisons
+	costRegexp            // regular expressions over the line
+	costExpression        // CEL programs over the message
+)
+
+// delivery is the state of a message passing the pipeline.
+type delivery struct {
+	message  *router.Message
+	rules    *rules
+	meta     *containerMeta
+	tag      string
+	failures parseFailures
+
+	// Set once the message was admitted
+	sampleRate int
+	latency    time.Duration
+}
+
+// filterStage drops messages that are not forwarded, for the given reason.
+type filterStage struct {
+	reason string
+	cost   int
+	allow  func(d *delivery) bool
+}
+
+// filterChain runs filter stages in order of their cost, cheapest first, so
+// expensive work is not spent on messages that cheap checks drop anyway.
+// Stages of the same cost keep their order. New stages declare their cost
+// rather than their position.
+type filterChain []filterStage
+
+// defaultFilters are the filters of every route.
+var defaultFilters = sortStages(filterChain{
+	{reason: dropExcluded, cost: costLookup, allow: func(d *delivery) bool {
+		// Honor label changes while streaming
+		exclude, _ := strconv.ParseBool(d.meta.labels[d.rules.excludeLabel])
+		return !exclude && !d.meta.options.exclude
+	}},
+	{reason: dropStreamFilter, cost: costLookup, allow: func(d *delivery) bool {
+		return d.rules.streamFilter.allow(d.message.Source, d.meta.labels, d.meta.options.stream)
+	}},
+	{reason: dropImageDigest, cost: costLookup, allow: func(d *delivery) bool {
+		return d.rules.digestFilter.allowed(d.meta.digest)
+	}},
+	{reason: dropHealthCheck, cost: costRegexp, allow: func(d *delivery) bool {
+		if !d.rules.dropHealthChecks || !isHealthCheck(d.message.Data) {
+			return true
+		}
+		logDebug("Skipping health check message", "container_id", d.message.Container.ID)
+		return false
+	}},
+	{reason: dropFilterExpression, cost: costExpression, allow: func(d *delivery) bool {
+		allowed, err := d.rules.filter.allow(d.message, d.meta.labels, d.tag)
+		d.failures.add(err)
+		return allowed
+	}},
+})
+
+// sortStages orders stages by cost.
+func sortStages(stages filterChain) filterChain {
+	sort.SliceStable(stages, func(i, j int) bool {
+		return stages[i].cost < stages[j].cost
+	})
+	return stages
+}
+
+// filter runs the stages, returning the reason of the first stage dropping
+// the message, or "" when all allow it.
+func (c filterChain) filter(d *delivery) string {
+	for _, stage := range c {
+		if !stage.allow(d) {
+			return stage.reason
+		}
+	}
+	return ""
+}
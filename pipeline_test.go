@@ -0,0 +1,149 @@
+package fluentd
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// discardPoster drops records after encoding them, as the fluent logger
+// would before writing.
+type discardPoster struct{}
+
+func (discardPoster) PostWithTime(tag string, t time.Time, message interface{}) error {
+	_, err := message.(flatRecord).MarshalMsg(nil)
+	return err
+}
+
+// messageMix returns count messages of a realistic mix: mostly short lines,
+// some large JSON lines, blank lines and stderr, from several containers.
+func messageMix(count int) []*router.Message {
+	json := `{"level":"info","msg":"request served","path":"/api/orders","user":"` +
+		strings.Repeat("x", 900) + `"}`
+	messages := make([]*router.Message, count)
+	for i := range messages {
+		var data string
+		switch i % 20 {
+		case 0, 1, 2, 3:
+			data = json
+		case 4:
+			data = "  \n"
+		default:
+			data = "2024-05-01T12:00:00Z INFO request served status=200 n=" + strconv.Itoa(i)
+		}
+		messages[i] = testMessage("app-"+strconv.Itoa(i%8), data, map[string]string{"service": "checkout"})
+		if i%20 == 5 {
+			messages[i].Source = "stderr"
+		}
+	}
+	return messages
+}
+
+func BenchmarkHandle(b *testing.B) {
+	benchmarks := map[string]map[string]string{
+		"default": nil,
+		"enriched": {
+			"tag_suffix_label":   "service",
+			"image_digest_field": "true",
+			"version_field":      "true",
+			"max_line_bytes":     "512",
+		},
+		"filtered": {
+			"stream_filter":       "stdout",
+			"filter_healthchecks": "true",
+			"filter_expression":   `!msg.contains("DEBUG")`,
+		},
+	}
+	messages := messageMix(1000)
+	for name, options := range benchmarks {
+		b.Run(name, func(b *testing.B) {
+			if options == nil {
+				options = make(map[string]string)
+			}
+			options["label_refresh_interval"] = "0"
+			ad := newTestAdapter(b, options, discardPoster{})
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ad.handle(ad.ctx, messages[i%len(messages)])
+			}
+		})
+	}
+}
+
+func BenchmarkStream(b *testing.B) {
+	messages := messageMix(1000)
+	for _, workers := range []int{1, 4} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			ad := newTestAdapter(b, map[string]string{
+				"workers":                strconv.Itoa(workers),
+				"label_refresh_interval": "0",
+			}, discardPoster{})
+			logstream := make(chan *router.Message, 1024)
+			go func() {
+				for i := 0; i < b.N; i++ {
+					logstream <- messages[i%len(messages)]
+				}
+				close(logstream)
+			}()
+			b.ReportAllocs()
+			b.ResetTimer()
+			ad.Stream(logstream)
+		})
+	}
+}
+
+func BenchmarkTag(b *testing.B) {
+	meta := newContainerMeta(testMessage("web", "", map[string]string{"service": "checkout"}).Container,
+		&optionSource{prefix: defaultOptionLabelPrefix})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		meta.tag("docker", "service")
+	}
+}
+
+func TestDefaultFiltersOrder(t *testing.T) {
+	if !sort.SliceIsSorted(defaultFilters, func(i, j int) bool {
+		return defaultFilters[i].cost < defaultFilters[j].cost
+	}) {
+		t.Error("default filters are not ordered by cost")
+	}
+	// Stages of the same cost keep their declared order
+	if defaultFilters[0].reason != dropExcluded {
+		t.Errorf("first filter is %s, want %s", defaultFilters[0].reason, dropExcluded)
+	}
+}
+
+func BenchmarkFilterChain(b *testing.B) {
+	config, err := LoadConfig("localhost:24224", map[string]string{
+		"filter_healthchecks": "true",
+		"filter_expression":   `!msg.contains("DEBUG")`,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	rules, err := newRules(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	source := newOptionSource(config)
+	messages := map[string]*router.Message{
+		// Dropped by the first, cheapest stage
+		"excluded": testMessage("web", "request served", map[string]string{defaultExcludeLabel: "true"}),
+		// Passes every stage, including the expression
+		"forwarded": testMessage("web", "request served", nil),
+	}
+	for name, message := range messages {
+		d := &delivery{message: message, rules: rules, meta: newContainerMeta(message.Container, source)}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				defaultFilters.filter(d)
+			}
+		})
+	}
+}
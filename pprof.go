@@ -0,0 +1,58 @@
+package fluentd
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"sync"
+)
+
+var (
+	pprofServersMu sync.Mutex
+	pprofServers   = make(map[string]bool)
+)
+
+// servePprof serves the runtime profiles under /debug/pprof/ on the given
+// address, starting the server once however many routes configure it. The
+// listener is opt-in, PPROF_ADDRESS is unset by default, and should be bound
+// to a private interface as profiles expose the process' internals.
+//
+// The benchmarks of the package cover the hot path in isolation:
+//
+//	go test -run '^$' -bench . -benchmem -cpuprofile cpu.out
+//
+// To evaluate it in production, run logspout with PPROF_ADDRESS=127.0.0.1:6060
+// under a realistic load, then record and compare profiles:
+//
+//	go tool pprof -top http://127.0.0.1:6060/debug/pprof/profile?seconds=30
+//	curl -o before.pb.gz http://127.0.0.1:6060/debug/pprof/allocs
+//	go tool pprof -diff_base before.pb.gz http://127.0.0.1:6060/debug/pprof/allocs
+//
+// Stream throughput shows under Adapter.handle, tag computation and filtering
+// under the rules, and encoding under flatRecord.MarshalMsg and PostWithTime.
+// The fluentd_adapter_post_duration_seconds and
+// fluentd_adapter_forward_latency_seconds histograms on METRICS_ADDRESS show
+// the effect on delivery.
+func servePprof(address string) {
+	if address == "" {
+		return
+	}
+
+	pprofServersMu.Lock()
+	defer pprofServersMu.Unlock()
+
+	if pprofServers[address] {
+		return
+	}
+	pprofServers[address] = true
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		logInfo("Serving pprof", "address", address)
+		err := http.ListenAndServe(address, mux)
+		logError("Pprof server stopped", "address", address, "error", err)
+	}()
+}
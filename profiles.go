@@ -0,0 +1,38 @@
+package fluentd
+
+import (
+	"sort"
+	"strings"
+)
+
+// bundledProfiles are defaults for common deployment shapes, selected with
+// FLUENTD_PROFILE. Every setting of a profile can still be overridden
+// individually by the config file, environment variables or route options.
+var bundledProfiles = map[string]map[string]string{
+	// Forward each record as soon as possible, giving up quickly on a slow
+	// collector rather than queueing behind it.
+	"low-latency": {
+		"async":         "true",
+		"buffer_limit":  "65536",
+		"retry_wait":    "100ms",
+		"write_timeout": "1s",
+	},
+	// Buffer generously and tolerate slow writes so bursts are absorbed
+	// instead of blocking containers.
+	"high-throughput": {
+		"async":         "true",
+		"buffer_limit":  "8388608",
+		"retry_wait":    "1s",
+		"write_timeout": "10s",
+	},
+}
+
+// bundledProfileNames lists the bundled profiles for error messages.
+func bundledProfileNames() string {
+	names := make([]string, 0, len(bundledProfiles))
+	for name := range bundledProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
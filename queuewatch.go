@@ -0,0 +1,83 @@
+package fluentd
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultQueueWarn = "50,80,95"
+
+var (
+	queueHighWater = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_high_water_mark",
+		Help:      "Highest depth a queue reached.",
+	}, []string{"route", "queue"})
+	queueWarnings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_warnings_total",
+		Help:      "Times a queue's utilization crossed a warning threshold.",
+	}, []string{"route", "queue", "threshold"})
+)
+
+func init() {
+	prometheus.MustRegister(queueHighWater, queueWarnings)
+}
+
+// queueWatch warns when a queue fills up because its consumer is slower than
+// its producers, before records are dropped. Each threshold warns once when
+// crossed upwards and again only after the queue drained below it.
+type queueWatch struct {
+	route      string
+	queue      string
+	address    string
+	capacity   int
+	thresholds []int
+
+	mu        sync.Mutex
+	crossed   int
+	highWater int
+}
+
+// newQueueWatch watches the named queue of the given capacity that feeds the
+// fluentd at address.
+func newQueueWatch(route, queue, address string, capacity int, thresholds []int) *queueWatch {
+	return &queueWatch{
+		route:      route,
+		queue:      queue,
+		address:    address,
+		capacity:   capacity,
+		thresholds: thresholds,
+	}
+}
+
+// observe checks the current depth of the queue.
+func (w *queueWatch) observe(depth int) {
+	if w.capacity <= 0 {
+		return
+	}
+	utilization := depth * 100 / w.capacity
+
+	w.mu.Lock()
+	if depth > w.highWater {
+		w.highWater = depth
+		queueHighWater.WithLabelValues(w.route, w.queue).Set(float64(depth))
+	}
+	crossed := 0
+	for crossed < len(w.thresholds) && utilization >= w.thresholds[crossed] {
+		crossed++
+	}
+	rising := crossed > w.crossed
+	w.crossed = crossed
+	w.mu.Unlock()
+
+	if rising {
+		threshold := strconv.Itoa(w.thresholds[crossed-1])
+		queueWarnings.WithLabelValues(w.route, w.queue, threshold).Inc()
+		logWarn("Queue filling up, its consumer is slower than the route", "route", w.route,
+			"queue", w.queue, "address", w.address, "depth", depth, "capacity", w.capacity,
+			"utilization_percent", utilization, "threshold_percent", threshold)
+	}
+}
@@ -0,0 +1,161 @@
+package fluentd
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// QuietWindowConfig declares a time window during which matching records are
+// dropped or downsampled, e.g. for noisy nightly batch jobs.
+type QuietWindowConfig struct {
+	Name string `yaml:"name"`
+	// Tag and Container are regular expressions matched against the record
+	// tag and container name. Empty patterns match everything.
+	Tag       string `yaml:"tag"`
+	Container string `yaml:"container"`
+	// Start and End are "HH:MM" times. Windows may span midnight.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// Days optionally restricts the window to weekdays such as "mon", "sat".
+	Days     []string `yaml:"days"`
+	Timezone string   `yaml:"timezone"`
+	// SampleRate forwards 1-in-N matching records; zero drops them all.
+	SampleRate int `yaml:"sample_rate"`
+}
+
+// quietWindow is a compiled QuietWindowConfig.
+type quietWindow struct {
+	tag        *regexp.Regexp
+	container  *regexp.Regexp
+	start      int
+	end        int
+	days       map[time.Weekday]bool
+	location   *time.Location
+	sampleRate int
+}
+
+// quietWindows holds the active quiet windows. They can be replaced at runtime
+// when the config file is reloaded.
+type quietWindows struct {
+	mu      sync.RWMutex
+	windows []*quietWindow
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// update compiles and installs the given windows, keeping the current ones on error.
+func (q *quietWindows) update(configs []QuietWindowConfig) error {
+	windows := make([]*quietWindow, 0, len(configs))
+	for _, config := range configs {
+		window, err := compileQuietWindow(config)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid quiet window %s", config.Name)
+		}
+		windows = append(windows, window)
+	}
+
+	q.mu.Lock()
+	q.windows = windows
+	q.mu.Unlock()
+	return nil
+}
+
+// match returns the sample rate of the first window matching the record at
+// the given time. It reports false when no window applies.
+func (q *quietWindows) match(tag, containerName string, now time.Time) (int, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	for _, window := range q.windows {
+		if window.tag != nil && !window.tag.MatchString(tag) {
+			continue
+		}
+		if window.container != nil && !window.container.MatchString(containerName) {
+			continue
+		}
+		if window.active(now) {
+			return window.sampleRate, true
+		}
+	}
+	return 0, false
+}
+
+// active reports whether the window is open at the given time.
+func (w *quietWindow) active(now time.Time) bool {
+	now = now.In(w.location)
+	minute := now.Hour()*60 + now.Minute()
+	day := now.Weekday()
+
+	switch {
+	case w.start <= w.end:
+		if minute < w.start || minute >= w.end {
+			return false
+		}
+	case minute >= w.start:
+	case minute < w.end:
+		// Past midnight, the window belongs to the previous day
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	return len(w.days) == 0 || w.days[day]
+}
+
+func compileQuietWindow(config QuietWindowConfig) (*quietWindow, error) {
+	window := &quietWindow{
+		days:       make(map[time.Weekday]bool),
+		location:   time.Local,
+		sampleRate: config.SampleRate,
+	}
+
+	var err error
+	if config.Tag != "" {
+		if window.tag, err = regexp.Compile(config.Tag); err != nil {
+			return nil, err
+		}
+	}
+	if config.Container != "" {
+		if window.container, err = regexp.Compile(config.Container); err != nil {
+			return nil, err
+		}
+	}
+	if window.start, err = parseClock(config.Start); err != nil {
+		return nil, err
+	}
+	if window.end, err = parseClock(config.End); err != nil {
+		return nil, err
+	}
+	for _, name := range config.Days {
+		key := strings.ToLower(name)
+		if len(key) > 3 {
+			key = key[:3]
+		}
+		day, found := weekdays[key]
+		if !found {
+			return nil, errors.New("Unknown day " + name)
+		}
+		window.days[day] = true
+	}
+	if config.Timezone != "" {
+		if window.location, err = time.LoadLocation(config.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	return window, nil
+}
+
+// parseClock parses an "HH:MM" time into minutes after midnight.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Invalid time %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
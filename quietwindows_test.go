@@ -0,0 +1,95 @@
+package fluentd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietWindows(t *testing.T) {
+	// A Tuesday
+	day := time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		config    QuietWindowConfig
+		tag       string
+		at        time.Duration
+		wantRate  int
+		wantMatch bool
+	}{
+		{
+			name:      "inside",
+			config:    QuietWindowConfig{Start: "02:00", End: "04:00", Timezone: "UTC"},
+			at:        3 * time.Hour,
+			wantMatch: true,
+		},
+		{
+			name:   "outside",
+			config: QuietWindowConfig{Start: "02:00", End: "04:00", Timezone: "UTC"},
+			at:     4 * time.Hour,
+		},
+		{
+			name:      "sampled",
+			config:    QuietWindowConfig{Start: "02:00", End: "04:00", Timezone: "UTC", SampleRate: 10},
+			at:        2 * time.Hour,
+			wantRate:  10,
+			wantMatch: true,
+		},
+		{
+			name:      "past midnight",
+			config:    QuietWindowConfig{Start: "23:00", End: "01:00", Timezone: "UTC", Days: []string{"monday"}},
+			at:        30 * time.Minute,
+			wantMatch: true,
+		},
+		{
+			name:   "other day",
+			config: QuietWindowConfig{Start: "02:00", End: "04:00", Timezone: "UTC", Days: []string{"sat", "sun"}},
+			at:     3 * time.Hour,
+		},
+		{
+			name:      "matching tag",
+			config:    QuietWindowConfig{Tag: `^batch\.`, Start: "02:00", End: "04:00", Timezone: "UTC"},
+			tag:       "batch.report",
+			at:        3 * time.Hour,
+			wantMatch: true,
+		},
+		{
+			name:   "other tag",
+			config: QuietWindowConfig{Tag: `^batch\.`, Start: "02:00", End: "04:00", Timezone: "UTC"},
+			tag:    "docker.web",
+			at:     3 * time.Hour,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := &quietWindows{}
+			if err := q.update([]QuietWindowConfig{test.config}); err != nil {
+				t.Fatal(err)
+			}
+			rate, match := q.match(test.tag, "web", day.Add(test.at))
+			if rate != test.wantRate || match != test.wantMatch {
+				t.Errorf("match = %d, %v; want %d, %v", rate, match, test.wantRate, test.wantMatch)
+			}
+		})
+	}
+}
+
+func TestQuietWindowsInvalid(t *testing.T) {
+	q := &quietWindows{}
+	kept := QuietWindowConfig{Start: "02:00", End: "04:00"}
+	if err := q.update([]QuietWindowConfig{kept}); err != nil {
+		t.Fatal(err)
+	}
+	for _, config := range []QuietWindowConfig{
+		{Name: "time", Start: "2am", End: "04:00"},
+		{Name: "day", Start: "02:00", End: "04:00", Days: []string{"someday"}},
+		{Name: "tag", Tag: "(", Start: "02:00", End: "04:00"},
+		{Name: "timezone", Start: "02:00", End: "04:00", Timezone: "Nowhere/Else"},
+	} {
+		if err := q.update([]QuietWindowConfig{config}); err == nil {
+			t.Errorf("invalid %s accepted", config.Name)
+		}
+	}
+	if len(q.windows) != 1 {
+		t.Errorf("%d windows after invalid updates, want the one in effect", len(q.windows))
+	}
+}
@@ -0,0 +1,151 @@
+package fluentd
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTenantQuotaSampleRate     = 100
+	defaultTenantQuotaReportInterval = 300
+)
+
+// tenantUsage is the forwarded and dropped volume of a tenant for one day.
+type tenantUsage struct {
+	day            string
+	bytes          int64
+	droppedBytes   int64
+	droppedRecords int64
+	lastReport     time.Time
+}
+
+// quotaReport is a snapshot of a tenant's usage, forwarded as a record so
+// platform teams can charge back.
+type quotaReport struct {
+	tenant string
+	quota  int64
+	usage  tenantUsage
+}
+
+// tenantQuotas enforces daily byte quotas per tenant, where the tenant is
+// identified by a container label. Beyond the quota, records are dropped or
+// downsampled depending on TENANT_QUOTA_ACTION.
+type tenantQuotas struct {
+	mu             sync.Mutex
+	label          string
+	defaultQuota   int64
+	quotas         map[string]int64
+	sampleRate     int
+	reportInterval time.Duration
+	usage          map[string]*tenantUsage
+}
+
+// newTenantQuotas creates quotas from the tenant quota configuration.
+func newTenantQuotas(config TenantQuotaConfig) *tenantQuotas {
+	q := &tenantQuotas{usage: make(map[string]*tenantUsage)}
+	q.reconfigure(config)
+	return q
+}
+
+// reconfigure applies new quotas, keeping the usage accounted so far today.
+func (q *tenantQuotas) reconfigure(config TenantQuotaConfig) {
+	sampleRate := 0
+	if config.Action == "sample" {
+		sampleRate = config.SampleRate
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.label = config.Label
+	q.defaultQuota = config.DailyBytes
+	q.quotas = config.Quotas
+	q.sampleRate = sampleRate
+	q.reportInterval = config.ReportInterval
+}
+
+// check returns the tenant of a container and the sample rate that applies to
+// its next record: 1 within quota, 0 to drop, or N to forward 1-in-N.
+func (q *tenantQuotas) check(labels map[string]string, now time.Time) (string, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.label == "" {
+		return "", 1
+	}
+	tenant := labels[q.label]
+	if tenant == "" {
+		return "", 1
+	}
+	quota := q.quota(tenant)
+	if quota <= 0 || q.current(tenant, now).bytes < quota {
+		return tenant, 1
+	}
+	return tenant, q.sampleRate
+}
+
+// record accounts a record of the given size for the tenant. Once per report
+// interval, and when the day rolls over, it returns a usage report.
+func (q *tenantQuotas) record(tenant string, size int, forwarded bool, now time.Time) *quotaReport {
+	if tenant == "" {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var report *quotaReport
+	usage, found := q.usage[tenant]
+	if found && usage.day != quotaDay(now) {
+		report = &quotaReport{tenant: tenant, quota: q.quota(tenant), usage: *usage}
+	}
+	usage = q.current(tenant, now)
+	if forwarded {
+		usage.bytes += int64(size)
+	} else {
+		usage.droppedBytes += int64(size)
+		usage.droppedRecords++
+	}
+
+	if report == nil && now.Sub(usage.lastReport) >= q.reportInterval {
+		usage.lastReport = now
+		report = &quotaReport{tenant: tenant, quota: q.quota(tenant), usage: *usage}
+	}
+	return report
+}
+
+// current returns the usage of the tenant for the current day.
+func (q *tenantQuotas) current(tenant string, now time.Time) *tenantUsage {
+	day := quotaDay(now)
+	usage, found := q.usage[tenant]
+	if !found || usage.day != day {
+		usage = &tenantUsage{day: day, lastReport: now}
+		q.usage[tenant] = usage
+	}
+	return usage
+}
+
+func (q *tenantQuotas) quota(tenant string) int64 {
+	if quota, found := q.quotas[tenant]; found {
+		return quota
+	}
+	return q.defaultQuota
+}
+
+// quotaDay returns the UTC day that quotas are accounted against.
+func quotaDay(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// fields returns the report as record fields.
+func (r *quotaReport) fields() map[string]string {
+	return map[string]string{
+		"tenant":          r.tenant,
+		"day":             r.usage.day,
+		"quota_bytes":     strconv.FormatInt(r.quota, 10),
+		"used_bytes":      strconv.FormatInt(r.usage.bytes, 10),
+		"dropped_bytes":   strconv.FormatInt(r.usage.droppedBytes, 10),
+		"dropped_records": strconv.FormatInt(r.usage.droppedRecords, 10),
+	}
+}
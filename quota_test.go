@@ -0,0 +1,116 @@
+package fluentd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTenantQuotas(t *testing.T) {
+	now := time.Date(2023, 11, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		config   TenantQuotaConfig
+		labels   map[string]string
+		used     int
+		wantRate int
+	}{
+		{name: "no tenant label", config: TenantQuotaConfig{DailyBytes: 10}, used: 100, wantRate: 1},
+		{
+			name:     "unlabeled container",
+			config:   TenantQuotaConfig{Label: "team", DailyBytes: 10},
+			used:     100,
+			wantRate: 1,
+		},
+		{
+			name:     "within quota",
+			config:   TenantQuotaConfig{Label: "team", DailyBytes: 100},
+			labels:   map[string]string{"team": "a"},
+			used:     50,
+			wantRate: 1,
+		},
+		{
+			name:     "beyond quota",
+			config:   TenantQuotaConfig{Label: "team", DailyBytes: 100, Action: "drop"},
+			labels:   map[string]string{"team": "a"},
+			used:     100,
+			wantRate: 0,
+		},
+		{
+			name:     "beyond quota sampled",
+			config:   TenantQuotaConfig{Label: "team", DailyBytes: 100, Action: "sample", SampleRate: 10},
+			labels:   map[string]string{"team": "a"},
+			used:     100,
+			wantRate: 10,
+		},
+		{
+			name: "tenant override",
+			config: TenantQuotaConfig{Label: "team", DailyBytes: 100,
+				Quotas: map[string]int64{"a": 1000}},
+			labels:   map[string]string{"team": "a"},
+			used:     500,
+			wantRate: 1,
+		},
+		{
+			name:     "unlimited",
+			config:   TenantQuotaConfig{Label: "team"},
+			labels:   map[string]string{"team": "a"},
+			used:     1 << 30,
+			wantRate: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := newTenantQuotas(test.config)
+			tenant, _ := q.check(test.labels, now)
+			q.record(tenant, test.used, true, now)
+			if _, rate := q.check(test.labels, now); rate != test.wantRate {
+				t.Errorf("rate = %d, want %d", rate, test.wantRate)
+			}
+		})
+	}
+}
+
+func TestTenantQuotaReports(t *testing.T) {
+	now := time.Date(2023, 11, 14, 23, 0, 0, 0, time.UTC)
+	q := newTenantQuotas(TenantQuotaConfig{Label: "team", DailyBytes: 100, ReportInterval: time.Minute})
+
+	if report := q.record("a", 10, true, now); report != nil {
+		t.Errorf("report before the interval: %v", report.fields())
+	}
+	q.record("a", 5, false, now)
+	report := q.record("a", 20, true, now.Add(time.Minute))
+	if report == nil {
+		t.Fatal("no report after the interval")
+	}
+	want := map[string]string{"tenant": "a", "day": "2023-11-14", "quota_bytes": "100", "used_bytes": "30",
+		"dropped_bytes": "5", "dropped_records": "1"}
+	for key, value := range want {
+		if got := report.fields()[key]; got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	// The day's final usage is reported once the day rolls over
+	report = q.record("a", 1, true, now.Add(2*time.Hour))
+	if report == nil || report.usage.day != "2023-11-14" || report.usage.bytes != 30 {
+		t.Errorf("report on rollover = %+v, want the usage of 2023-11-14", report)
+	}
+}
+
+func TestQuotaCountsGuaranteedLines(t *testing.T) {
+	// The first lines skip the quota's drop decision, but not its accounting
+	ad := newTestAdapter(t, map[string]string{
+		"forward_first_lines":      "5",
+		"tenant_label":             "team",
+		"tenant_daily_quota_bytes": "1",
+	}, &fakePoster{})
+	labels := map[string]string{"team": "a"}
+	stream(ad, testMessage("web", "0123456789", labels), testMessage("web", "0123456789", labels),
+		testMessage("web", "0123456789", labels))
+
+	ad.quotas.mu.Lock()
+	defer ad.quotas.mu.Unlock()
+	if usage := ad.quotas.usage["a"]; usage == nil || usage.bytes != 30 {
+		t.Errorf("usage = %+v, want 30 bytes of guaranteed lines", usage)
+	}
+}
@@ -0,0 +1,240 @@
+package fluentd
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultRateLimitLines          = 0
+	defaultRateLimitReportInterval = 10
+	bucketIdleTimeout              = 5 * time.Minute
+
+	// suppressedSweepInterval is how often suppressed lines are checked for
+	// a due report.
+	suppressedSweepInterval = time.Second
+)
+
+// tokenBucket tracks the rate limit state of a single container, and the
+// last suppressed line with the tag and writer suppressed lines are reported
+// to.
+type tokenBucket struct {
+	tokens     float64
+	last       time.Time
+	suppressed int
+	lastReport time.Time
+	message    *router.Message
+	tag        string
+	writer     Poster
+}
+
+// suppressedLines is a report of the lines of a container suppressed by the
+// rate limiter.
+type suppressedLines struct {
+	message *router.Message
+	tag     string
+	writer  Poster
+	count   int
+}
+
+// rateLimiter applies a token bucket per container. A rate of zero disables it.
+// suppressing is signalled when a container's lines start being suppressed,
+// so reports of containers that went quiet are swept only while there are any.
+type rateLimiter struct {
+	mu             sync.Mutex
+	rate           float64
+	burst          float64
+	reportInterval time.Duration
+	buckets        map[string]*tokenBucket
+	lastSweep      time.Time
+	suppressing    chan struct{}
+}
+
+// newRateLimiter creates a rate limiter from the rate limit configuration.
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	l := &rateLimiter{buckets: make(map[string]*tokenBucket), suppressing: make(chan struct{}, 1)}
+	l.reconfigure(config)
+	return l
+}
+
+// reconfigure applies a new rate limit configuration, keeping bucket state.
+func (l *rateLimiter) reconfigure(config RateLimitConfig) {
+	burst := config.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = float64(config.Lines)
+	l.burst = float64(burst)
+	l.reportInterval = config.ReportInterval
+}
+
+// allow reports whether a line from the given container may be forwarded. When
+// lines have been suppressed, it also returns the number to report, either once
+// the limiter lets lines through again or every report interval while engaged.
+// Suppressed lines of containers that went quiet are reported by sweep, to
+// the tag and writer of the last one.
+func (l *rateLimiter) allow(message *router.Message, tag string, writer Poster, now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	l.sweepIdle(now)
+	bucket, found := l.buckets[message.Container.ID]
+	if !found {
+		bucket = &tokenBucket{tokens: l.burst, last: now, lastReport: now}
+		l.buckets[message.Container.ID] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	} else {
+		if bucket.suppressed == 0 {
+			select {
+			case l.suppressing <- struct{}{}:
+			default:
+			}
+		}
+		bucket.suppressed++
+		bucket.message, bucket.tag, bucket.writer = message, tag, writer
+	}
+
+	report := 0
+	if bucket.suppressed > 0 && (allowed || now.Sub(bucket.lastReport) >= l.reportInterval) {
+		report = bucket.suppressed
+		bucket.suppressed = 0
+		bucket.lastReport = now
+		bucket.message, bucket.writer = nil, nil
+	}
+	return allowed, report
+}
+
+// sweep returns the suppressed lines whose report interval elapsed, and
+// whether suppressed lines remain to be reported.
+func (l *rateLimiter) sweep(now time.Time) ([]*suppressedLines, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var due []*suppressedLines
+	pending := false
+	for _, bucket := range l.buckets {
+		if bucket.suppressed == 0 {
+			continue
+		}
+		if now.Sub(bucket.lastReport) < l.reportInterval {
+			pending = true
+			continue
+		}
+		due = append(due, bucket.report(now))
+	}
+	return due, pending
+}
+
+// flush returns the suppressed lines of all containers.
+func (l *rateLimiter) flush() []*suppressedLines {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var due []*suppressedLines
+	for _, bucket := range l.buckets {
+		if bucket.suppressed > 0 {
+			due = append(due, bucket.report(time.Now()))
+		}
+	}
+	return due
+}
+
+// report takes the suppressed lines of the bucket. The caller holds the lock.
+func (b *tokenBucket) report(now time.Time) *suppressedLines {
+	lines := &suppressedLines{message: b.message, tag: b.tag, writer: b.writer, count: b.suppressed}
+	b.suppressed = 0
+	b.lastReport = now
+	b.message, b.writer = nil, nil
+	return lines
+}
+
+// sweepIdle forgets buckets of containers that have not logged for a while.
+// Their suppressed lines were reported by sweep by then.
+func (l *rateLimiter) sweepIdle(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketIdleTimeout {
+		return
+	}
+	l.lastSweep = now
+	for id, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= bucketIdleTimeout {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// runSuppressed reports the suppressed lines of containers that stopped
+// logging while rate limited, until the adapter is closed. It only sweeps
+// while lines are suppressed, so it doesn't wake an adapter without a rate
+// limit or whose containers are within it.
+func (ad *Adapter) runSuppressed() {
+	for {
+		select {
+		case <-ad.limiter.suppressing:
+		case <-ad.ctx.Done():
+			return
+		}
+		if !ad.sweepSuppressed() {
+			return
+		}
+	}
+}
+
+// sweepSuppressed reports suppressed lines as their report interval elapses,
+// until none are left. It returns false once the adapter is closed.
+func (ad *Adapter) sweepSuppressed() bool {
+	ticker := time.NewTicker(suppressedSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			due, pending := ad.limiter.sweep(now)
+			ad.reportSuppressed(due)
+			if !pending {
+				return true
+			}
+		case <-ad.ctx.Done():
+			return false
+		}
+	}
+}
+
+// reportSuppressed posts a record with the number of suppressed lines of
+// each container.
+func (ad *Adapter) reportSuppressed(reports []*suppressedLines) {
+	for _, lines := range reports {
+		ad.postSuppressed(context.Background(), lines.writer, lines.tag, lines.message, lines.count)
+	}
+}
+
+// postSuppressed posts the record reporting count suppressed lines of the
+// message's container.
+func (ad *Adapter) postSuppressed(ctx context.Context, writer Poster, tag string, message *router.Message,
+	count int) {
+	record := containerRecord(message)
+	record["log"] = "suppressed " + strconv.Itoa(count) + " lines"
+	record["suppressed_lines"] = strconv.Itoa(count)
+	ad.post(ctx, writer, tag, message.Time, record)
+	releaseRecord(record)
+}
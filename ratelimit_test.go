@@ -0,0 +1,98 @@
+package fluentd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSweep(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	l := newRateLimiter(RateLimitConfig{Lines: 1, Burst: 1, ReportInterval: 10 * time.Second})
+	message := testMessage("web", "line", nil)
+	for i := 0; i < 3; i++ {
+		l.allow(message, "tag", nil, start)
+	}
+	select {
+	case <-l.suppressing:
+	default:
+		t.Error("suppressing not signalled")
+	}
+
+	if due, pending := l.sweep(start.Add(5 * time.Second)); len(due) != 0 || !pending {
+		t.Errorf("sweep before the interval = %d reports, pending %v; want none and pending", len(due), pending)
+	}
+	due, pending := l.sweep(start.Add(10 * time.Second))
+	if len(due) != 1 || due[0].count != 2 || due[0].tag != "tag" || pending {
+		t.Errorf("sweep after the interval = %+v, pending %v; want 2 lines and none pending", due, pending)
+	}
+}
+
+func TestRateLimitReportsOnClose(t *testing.T) {
+	writer := &fakePoster{}
+	ad := newTestAdapter(t, map[string]string{"rate_limit_lines": "1", "rate_limit_report_interval": "60"}, writer)
+	stream(ad, testMessage("web", "a", nil), testMessage("web", "b", nil), testMessage("web", "c", nil))
+	if err := ad.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	posts := writer.received()
+	if len(posts) != 2 {
+		t.Fatalf("got %d posts, want the first line and the suppression report", len(posts))
+	}
+	if got := posts[1].record["suppressed_lines"]; got != "2" {
+		t.Errorf("suppressed_lines = %q, want 2", got)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		config RateLimitConfig
+		// offsets of the lines from start
+		offsets     []time.Duration
+		wantAllowed []bool
+		wantReports []int
+	}{
+		{
+			name:        "disabled",
+			offsets:     []time.Duration{0, 0, 0},
+			wantAllowed: []bool{true, true, true},
+			wantReports: []int{0, 0, 0},
+		},
+		{
+			name:        "burst",
+			config:      RateLimitConfig{Lines: 1, Burst: 2, ReportInterval: time.Minute},
+			offsets:     []time.Duration{0, 0, 0},
+			wantAllowed: []bool{true, true, false},
+			wantReports: []int{0, 0, 0},
+		},
+		{
+			name:        "refill reports suppressed lines",
+			config:      RateLimitConfig{Lines: 1, Burst: 1, ReportInterval: time.Minute},
+			offsets:     []time.Duration{0, 0, 0, time.Second},
+			wantAllowed: []bool{true, false, false, true},
+			wantReports: []int{0, 0, 0, 2},
+		},
+		{
+			name:        "report while engaged",
+			config:      RateLimitConfig{Lines: 1, Burst: 1, ReportInterval: 10 * time.Millisecond},
+			offsets:     []time.Duration{0, 0, 20 * time.Millisecond},
+			wantAllowed: []bool{true, false, false},
+			wantReports: []int{0, 0, 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newRateLimiter(test.config)
+			message := testMessage("web", "line", nil)
+			for i, offset := range test.offsets {
+				allowed, report := l.allow(message, "tag", nil, start.Add(offset))
+				if allowed != test.wantAllowed[i] || report != test.wantReports[i] {
+					t.Errorf("line %d: allow = %v, %d; want %v, %d", i, allowed, report, test.wantAllowed[i],
+						test.wantReports[i])
+				}
+			}
+		})
+	}
+}
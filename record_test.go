@@ -0,0 +1,110 @@
+package fluentd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// goldenRecord is the JSON form of a posted record in the golden files.
+type goldenRecord struct {
+	Tag    string            `json:"tag"`
+	Time   string            `json:"time"`
+	Record map[string]string `json:"record"`
+}
+
+// TestRecordGolden checks the exact records posted for each enrichment
+// against testdata/records/<name>.json. Run with -update to rewrite them.
+func TestRecordGolden(t *testing.T) {
+	pinned := testMessage("web", "hello", nil)
+	pinned.Container.Config.Image = "registry.example.com/web@sha256:4c0ffee"
+
+	long := testMessage("web", "ünïcödé line longer than the limit", nil)
+
+	badLabel := testMessage("web", "hello", map[string]string{defaultOptionLabelPrefix + "sample_rate": "often"})
+
+	tests := []struct {
+		name    string
+		options map[string]string
+		message *router.Message
+	}{
+		{name: "default", message: testMessage("web", "hello", nil)},
+		{name: "stderr", message: func() *router.Message {
+			message := testMessage("web", "oops", nil)
+			message.Source = "stderr"
+			return message
+		}()},
+		{name: "image_digest", options: map[string]string{"image_digest_field": "true"}, message: pinned},
+		{name: "version", options: map[string]string{"version_field": "true"}, message: testMessage("web", "hello", nil)},
+		{name: "truncated", options: map[string]string{"max_line_bytes": "8"}, message: long},
+		{name: "strict_annotate", options: map[string]string{"strict_mode": "annotate"}, message: badLabel},
+		{
+			name: "all",
+			options: map[string]string{
+				"image_digest_field": "true",
+				"version_field":      "true",
+				"max_line_bytes":     "8",
+				"strict_mode":        "annotate",
+				"tag_suffix_label":   "service",
+			},
+			message: func() *router.Message {
+				message := testMessage("web", "ünïcödé line longer than the limit", map[string]string{
+					"service":                                "checkout",
+					defaultOptionLabelPrefix + "sample_rate": "often",
+				})
+				message.Container.Config.Image = pinned.Container.Config.Image
+				return message
+			}(),
+		},
+	}
+
+	version := Version
+	Version = "v1.2.3"
+	defer func() { Version = version }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writer := &fakePoster{}
+			stream(newTestAdapter(t, test.options, writer), test.message)
+			posts := writer.received()
+			if len(posts) != 1 {
+				t.Fatalf("got %d posts, want 1", len(posts))
+			}
+			got, err := json.MarshalIndent(goldenRecord{
+				Tag:    posts[0].tag,
+				Time:   posts[0].time.UTC().Format(time.RFC3339Nano),
+				Record: posts[0].record,
+			}, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", "records", test.name+".json")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("record differs from %s:\ngot:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package fluentd
+
+import "sync"
+
+// recordPool reuses the maps of container records. Writers encode a record
+// before PostWithTime returns and the mirror copies it, so a record may be
+// released once posted.
+var recordPool = sync.Pool{
+	New: func() interface{} {
+		return make(map[string]string, 8)
+	},
+}
+
+// releaseRecord returns a record from containerRecord to the pool. The record
+// must not be used afterwards.
+func releaseRecord(record map[string]string) {
+	for key := range record {
+		delete(record, key)
+	}
+	recordPool.Put(record)
+}
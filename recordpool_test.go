@@ -0,0 +1,37 @@
+package fluentd
+
+import "testing"
+
+func TestReleaseRecord(t *testing.T) {
+	record := containerRecord(testMessage("web", "hello", nil))
+	record["extra"] = "field"
+	releaseRecord(record)
+	if len(record) != 0 {
+		t.Errorf("released record still has %d fields", len(record))
+	}
+}
+
+func BenchmarkContainerRecord(b *testing.B) {
+	message := testMessage("web", "request served path=/api/orders status=200", nil)
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			record := containerRecord(message)
+			flatRecord(record).MarshalMsg(nil)
+			releaseRecord(record)
+		}
+	})
+	// The allocations without recordPool, for comparison
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			record := map[string]string{
+				"log":            message.Data,
+				"container_id":   message.Container.ID,
+				"container_name": message.Container.Name,
+				"source":         message.Source,
+			}
+			flatRecord(record).MarshalMsg(nil)
+		}
+	})
+}
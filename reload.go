@@ -0,0 +1,224 @@
+package fluentd
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// rules is the reloadable, non-transport part of an adapter: tagging, filters
+// and sampling. It is replaced as a whole when the configuration is reloaded.
+type rules struct {
+	tagPrefix        string
+	tagSuffixLabel   string
+	excludeLabel     string
+	dropHealthChecks bool
+	strictMode       string
+	latencyField     bool
+	maxLineBytes     int
+	versionField     bool
+	sampler          *sampler
+	streamFilter     *streamFilter
+	filter           *expressionFilter
+	digestFilter     *digestFilter
+}
+
+// newRules creates the rules of a configuration.
+func newRules(config *Config) (*rules, error) {
+	// Route options take precedence over container labels
+	filterLabel := config.FilterExpressionLabel
+	if config.Source("filter_expression") == sourceRoute {
+		filterLabel = ""
+	}
+	filter, err := newExpressionFilter(config.FilterExpression, filterLabel)
+	if err != nil {
+		return nil, err
+	}
+	sampler := newSampler(config.Sampling, config.Source("sample_rate") == sourceRoute)
+	streamFilter := newStreamFilter(config.StreamFilter, config.StreamFilterLabel,
+		config.Source("stream_filter") == sourceRoute)
+	return &rules{
+		tagPrefix:        config.TagPrefix,
+		tagSuffixLabel:   config.TagSuffixLabel,
+		excludeLabel:     config.ExcludeLabel,
+		dropHealthChecks: config.FilterHealthchecks,
+		strictMode:       config.StrictMode,
+		latencyField:     config.LatencyField,
+		maxLineBytes:     config.MaxLineBytes,
+		versionField:     config.VersionField,
+		sampler:          sampler,
+		streamFilter:     streamFilter,
+		filter:           filter,
+		digestFilter:     newDigestFilter(config.ImageDigest),
+	}, nil
+}
+
+// currentRules returns the rules in effect.
+func (ad *Adapter) currentRules() *rules {
+	ad.mu.RLock()
+	defer ad.mu.RUnlock()
+	return ad.rules
+}
+
+// currentConfig returns the configuration last loaded.
+func (ad *Adapter) currentConfig() *Config {
+	ad.mu.RLock()
+	defer ad.mu.RUnlock()
+	return ad.config
+}
+
+// primary returns the writer for the route's own fluentd address.
+func (ad *Adapter) primary() Poster {
+	ad.mu.RLock()
+	defer ad.mu.RUnlock()
+	return ad.writer
+}
+
+// reload re-reads the configuration and applies its non-transport parts:
+// rules, quiet windows, dedupe, rate limits, quotas and container options.
+// Connections to fluentd and their buffered records are kept; transport
+// settings require a restart, see keepStatic. The configuration of adapters
+// created by an embedding program is theirs and not reloaded.
+func (ad *Adapter) reload() error {
+	if ad.load == nil {
+		return errEmbeddedReload
+	}
+	config, err := ad.load()
+	if err != nil {
+		return err
+	}
+	config.keepStatic(ad.currentConfig())
+	rules, err := newRules(config)
+	if err != nil {
+		return err
+	}
+	if err := ad.quietWindows.update(config.QuietWindows); err != nil {
+		return err
+	}
+	ad.deduper.reconfigure(config.DedupeWindow)
+	ad.startupGuard.reconfigure(config.ForwardFirstLines, config.ForwardFirstLinesGap)
+	ad.limiter.reconfigure(config.RateLimit)
+	ad.hostLimiter.reconfigure(config.HostRateLimit)
+	ad.quotas.reconfigure(config.TenantQuota)
+	ad.labels.reconfigure(newOptionSource(config))
+	ad.metrics.health.setTolerance(config.ReadyTolerance)
+
+	ad.mu.Lock()
+	ad.rules = rules
+	ad.config = config
+	ad.mu.Unlock()
+
+	logInfo("Reloaded configuration", "route", ad.address, "config", config)
+	return nil
+}
+
+// errEmbeddedReload is returned when reloading an adapter created by an
+// embedding program.
+var errEmbeddedReload = errBadRequest("the configuration of an embedded adapter is not reloaded")
+
+// keepStatic copies the settings a reload doesn't apply from the
+// configuration in effect: the connections to fluentd, the servers and signal
+// handling, and the components set up once when the adapter is created.
+// Everything reading the configuration later then sees the settings in
+// effect rather than ones that wait for a restart.
+func (c *Config) keepStatic(from *Config) {
+	c.ConfigFile, c.ConfigReloadInterval = from.ConfigFile, from.ConfigReloadInterval
+	c.ConnectionMaxRetries, c.ConnectionRetryWait = from.ConnectionMaxRetries, from.ConnectionRetryWait
+
+	c.Writer = from.Writer
+	c.Destinations, c.RouteLabel, c.Routes = from.Destinations, from.RouteLabel, from.Routes
+	c.Mirror, c.DestinationQueueSize, c.QueueWarn = from.Mirror, from.DestinationQueueSize, from.QueueWarn
+	c.Workers = from.Workers
+
+	c.Admin, c.MetricsAddress, c.PprofAddress = from.Admin, from.MetricsAddress, from.PprofAddress
+	c.Expvar, c.HandleSignals = from.Expvar, from.HandleSignals
+	c.Statsd, c.Stats, c.Tracing = from.Statsd, from.Stats, from.Tracing
+
+	c.Audit, c.DropAlarm = from.Audit, from.DropAlarm
+	c.LabelRefreshInterval, c.ContainerStatsMax, c.TagStatsTop = from.LabelRefreshInterval,
+		from.ContainerStatsMax, from.TagStatsTop
+	c.DebugSampleEvery, c.Selftest = from.DebugSampleEvery, from.Selftest
+	c.MemoryLimit, c.CPULimit = from.MemoryLimit, from.CPULimit
+	c.Script, c.Wasm = from.Script, from.Wasm
+}
+
+// watchReload reloads the configuration when the config file changes, and on
+// SIGHUP if the adapter handles signals, see signalHandler.
+func (ad *Adapter) watchReload(config *Config) error {
+	if config.HandleSignals {
+		handleSignals(ad)
+	}
+
+	if config.ConfigFile == "" || ad.load == nil {
+		return nil
+	}
+	return watchConfigFile(ad.ctx, config.ConfigFile, config.ConfigReloadInterval, func(*fileConfig) error {
+		return ad.reload()
+	})
+}
+
+// setSampleRate replaces the global sample rate until the next reload.
+func (ad *Adapter) setSampleRate(rate int) {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+
+	rules := *ad.rules
+	rules.sampler = &sampler{rate: rate, label: ad.rules.sampler.label, fixed: ad.rules.sampler.fixed}
+	ad.rules = &rules
+}
+
+// setFilterExpression replaces the global filter expression until the next
+// reload. An empty expression forwards everything.
+func (ad *Adapter) setFilterExpression(expression string) error {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+
+	filter, err := newExpressionFilter(expression, ad.rules.filter.label)
+	if err != nil {
+		return errBadRequest(err.Error())
+	}
+	rules := *ad.rules
+	rules.filter = filter
+	ad.rules = &rules
+	return nil
+}
+
+// reconnect replaces the writer for the route's own fluentd address with a new
+// connection on request of the admin API.
+func (ad *Adapter) reconnect() error {
+	if err := ad.replaceWriter(); err != nil {
+		return err
+	}
+	ad.connections.reconnected()
+	return nil
+}
+
+// replaceWriter replaces the writer for the route's own fluentd address with
+// a new connection. Closing the previous writer flushes its buffered records.
+func (ad *Adapter) replaceWriter() error {
+	writer, err := newWriter(ad.currentConfig().Writer, func(err error) { ad.written(true, err) })
+	if err != nil {
+		return err
+	}
+
+	// Once closed, Close may already have closed the current writer, and
+	// would not close the new one
+	ad.mu.Lock()
+	if ad.ctx.Err() != nil {
+		ad.mu.Unlock()
+		if closer, ok := writer.(io.Closer); ok {
+			closer.Close()
+		}
+		return errors.New("adapter closed")
+	}
+	previous := ad.writer
+	ad.writer = writer
+	ad.mu.Unlock()
+
+	if closer, ok := previous.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logError("Close failed", "address", ad.address, "error", err)
+		}
+	}
+	return nil
+}
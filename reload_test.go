@@ -0,0 +1,114 @@
+package fluentd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(ad *Adapter) interface{}
+		want  interface{}
+	}{
+		// Rules and limits are applied
+		{
+			name:  "tag prefix",
+			env:   map[string]string{"TAG_PREFIX": "reloaded"},
+			check: func(ad *Adapter) interface{} { return ad.currentRules().tagPrefix },
+			want:  "reloaded",
+		},
+		{
+			name:  "dedupe window",
+			env:   map[string]string{"DEDUPE_WINDOW": "5"},
+			check: func(ad *Adapter) interface{} { return ad.currentConfig().DedupeWindow },
+			want:  5 * time.Second,
+		},
+		{
+			name: "rate limit",
+			env:  map[string]string{"RATE_LIMIT_LINES": "7"},
+			check: func(ad *Adapter) interface{} {
+				ad.limiter.mu.Lock()
+				defer ad.limiter.mu.Unlock()
+				return ad.limiter.rate
+			},
+			want: 7.0,
+		},
+		// Transport and server settings keep the values in effect
+		{
+			name:  "async",
+			env:   map[string]string{"FLUENTD_ASYNC_CONNECT": "true"},
+			check: func(ad *Adapter) interface{} { return ad.currentConfig().Writer.Async },
+			want:  false,
+		},
+		{
+			name:  "connection retry wait",
+			env:   map[string]string{"CONNECTION_RETRY_WAIT": "9"},
+			check: func(ad *Adapter) interface{} { return ad.currentConfig().ConnectionRetryWait },
+			want:  time.Second,
+		},
+		{
+			name:  "admin address",
+			env:   map[string]string{"ADMIN_ADDRESS": "127.0.0.1:0", "ADMIN_TOKEN": "secret"},
+			check: func(ad *Adapter) interface{} { return ad.currentConfig().Admin.Address },
+			want:  "",
+		},
+		{
+			name:  "metrics address",
+			env:   map[string]string{"METRICS_ADDRESS": "127.0.0.1:0"},
+			check: func(ad *Adapter) interface{} { return ad.currentConfig().MetricsAddress },
+			want:  "",
+		},
+		{
+			name:  "workers",
+			env:   map[string]string{"WORKERS": "8"},
+			check: func(ad *Adapter) interface{} { return ad.currentConfig().Workers.Count },
+			want:  1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ad := newForwardAdapter(t, newFakeFluentd(t, nil, 0), nil)
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			if err := ad.reload(); err != nil {
+				t.Fatalf("reload: %v", err)
+			}
+			if got := test.check(ad); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	ad := newForwardAdapter(t, newFakeFluentd(t, nil, 0), nil)
+	t.Setenv("TAG_PREFIX", "kept")
+	if err := ad.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	// An invalid configuration leaves the one in effect
+	t.Setenv("TAG_PREFIX", "rejected")
+	t.Setenv("RATE_LIMIT_LINES", "many")
+	if err := ad.reload(); err == nil {
+		t.Fatal("reload succeeded with an invalid configuration")
+	}
+	if got := ad.currentRules().tagPrefix; got != "kept" {
+		t.Errorf("tag prefix = %q, want kept", got)
+	}
+}
+
+func TestReloadEmbedded(t *testing.T) {
+	// The configuration of an embedding program is kept as given
+	ad := newTestAdapter(t, map[string]string{"tag_prefix": "given"}, &fakePoster{})
+	t.Setenv("TAG_PREFIX", "reloaded")
+	if err := ad.reload(); err != errEmbeddedReload {
+		t.Fatalf("reload = %v, want %v", err, errEmbeddedReload)
+	}
+	if got := ad.currentRules().tagPrefix; got != "given" {
+		t.Errorf("tag prefix = %q, want given", got)
+	}
+}
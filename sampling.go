@@ -0,0 +1,56 @@
+package fluentd
+
+import (
+	"math/rand"
+	"strconv"
+)
+
+const (
+	defaultSampleRate      = 1
+	defaultSampleRateLabel = "fluentd.sample_rate"
+)
+
+// sampler forwards 1-in-N records, where N is taken from a container label
+// when present and from the global SAMPLE_RATE otherwise. A rate given as a
+// route option is fixed: container labels can't override it.
+type sampler struct {
+	rate  int
+	label string
+	fixed bool
+}
+
+// newSampler creates a sampler from the sampling configuration.
+func newSampler(config SamplingConfig, fixed bool) *sampler {
+	return &sampler{
+		rate:  config.Rate,
+		label: config.Label,
+		fixed: fixed,
+	}
+}
+
+// rateFor returns the sample rate that applies to a container with the given
+// labels. A positive override, taken from the container options, takes
+// precedence over the label.
+func (s *sampler) rateFor(labels map[string]string, override int) int {
+	if s.fixed {
+		return s.rate
+	}
+	if override > 0 {
+		return override
+	}
+	value, found := labels[s.label]
+	if !found {
+		return s.rate
+	}
+	rate, err := strconv.Atoi(value)
+	if err != nil || rate < 1 {
+		logDebug("Invalid sample rate label value", "label", s.label, "value", value)
+		return s.rate
+	}
+	return rate
+}
+
+// keep reports whether a record sampled at the given rate should be forwarded.
+func (s *sampler) keep(rate int) bool {
+	return rate <= 1 || rand.Intn(rate) == 0
+}
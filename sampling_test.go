@@ -0,0 +1,62 @@
+package fluentd
+
+import "testing"
+
+func TestSamplerRateFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     int
+		fixed    bool
+		labels   map[string]string
+		override int
+		want     int
+	}{
+		{name: "global rate", rate: 5, want: 5},
+		{name: "label", rate: 5, labels: map[string]string{defaultSampleRateLabel: "10"}, want: 10},
+		{name: "invalid label", rate: 5, labels: map[string]string{defaultSampleRateLabel: "zero"}, want: 5},
+		{name: "label below one", rate: 5, labels: map[string]string{defaultSampleRateLabel: "0"}, want: 5},
+		{
+			name:     "container option",
+			rate:     5,
+			labels:   map[string]string{defaultSampleRateLabel: "10"},
+			override: 20,
+			want:     20,
+		},
+		{
+			name:     "fixed by route option",
+			rate:     5,
+			fixed:    true,
+			labels:   map[string]string{defaultSampleRateLabel: "10"},
+			override: 20,
+			want:     5,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newSampler(SamplingConfig{Rate: test.rate, Label: defaultSampleRateLabel}, test.fixed)
+			if got := s.rateFor(test.labels, test.override); got != test.want {
+				t.Errorf("rate = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSamplerKeep(t *testing.T) {
+	s := newSampler(SamplingConfig{Rate: 1}, false)
+	for _, rate := range []int{0, 1} {
+		if !s.keep(rate) {
+			t.Errorf("record dropped at rate %d", rate)
+		}
+	}
+
+	// 1-in-N on average
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if s.keep(10) {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Errorf("kept %d of 10000 records at rate 10, want about 1000", kept)
+	}
+}
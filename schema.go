@@ -0,0 +1,55 @@
+package fluentd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// OptionSchema describes a supported setting.
+type OptionSchema struct {
+	Option  string // route option and config file options key
+	Env     string // environment variable
+	Type    string
+	Default string
+}
+
+// describe records a setting in the schema, once per option.
+func (l *configLoader) describe(key, envKey, kind, fallback string) {
+	for _, option := range l.schema {
+		if option.Option == key {
+			return
+		}
+	}
+	l.schema = append(l.schema, OptionSchema{Option: key, Env: envKey, Type: kind, Default: fallback})
+}
+
+// Schema returns every supported setting with its default, in the order
+// settings are resolved. It doesn't depend on the environment.
+func Schema() []OptionSchema {
+	l := &configLoader{settings: &settings{}, static: true}
+	l.load("")
+	return l.schema
+}
+
+// PrintSchema writes the supported settings as a table.
+func PrintSchema(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "OPTION\tVARIABLE\tTYPE\tDEFAULT")
+	for _, option := range Schema() {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", option.Option, option.Env, option.Type, option.Default)
+	}
+	return table.Flush()
+}
+
+// Validate checks the configuration given by the environment, the config file
+// and the route options, as the adapter would when started, without
+// connecting to fluentd.
+func Validate(address string, options map[string]string) error {
+	config, err := LoadConfig(address, options)
+	if err != nil {
+		return err
+	}
+	_, err = newRules(config)
+	return err
+}
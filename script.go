@@ -0,0 +1,114 @@
+package fluentd
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"go.starlark.net/starlark"
+)
+
+const defaultScriptMaxSteps = 100000
+
+// ScriptConfig configures the optional Starlark record script.
+type ScriptConfig struct {
+	File     string
+	MaxSteps int
+}
+
+// recordScript transforms records with a Starlark script, for changes that
+// would otherwise need a custom build. The script given by SCRIPT_FILE
+// defines
+//
+//	def transform(record, container):
+//	    record["team"] = container["labels"].get("team", "unknown")
+//	    return record
+//
+// record is a dict of the record's fields and container a dict with the id,
+// name, image and labels of the container. transform returns the record to
+// post, or None to drop the message. Every call may execute at most
+// SCRIPT_MAX_STEPS steps. A call that fails, exceeds its steps or returns
+// another value leaves the record unchanged.
+//
+// The script is compiled once when the adapter is created; configuration
+// reloads don't re-read it.
+type recordScript struct {
+	file      string
+	maxSteps  uint64
+	transform starlark.Callable
+}
+
+// newRecordScript compiles the script of the configuration. It returns nil
+// when no script is configured.
+func newRecordScript(config ScriptConfig) (*recordScript, error) {
+	if config.File == "" {
+		return nil, nil
+	}
+	src, err := os.ReadFile(config.File)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read SCRIPT_FILE")
+	}
+	thread := &starlark.Thread{Name: "load " + config.File}
+	globals, err := starlark.ExecFile(thread, config.File, src, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid SCRIPT_FILE %s", config.File)
+	}
+	transform, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		return nil, errors.Errorf("SCRIPT_FILE %s must define transform(record, container)", config.File)
+	}
+	// Frozen values may be shared by the threads of concurrent calls
+	globals.Freeze()
+	return &recordScript{file: config.File, maxSteps: uint64(config.MaxSteps), transform: transform}, nil
+}
+
+// middleware returns the script as a middleware.
+func (s *recordScript) middleware(d *delivery, record map[string]string) (map[string]string, bool) {
+	fields := starlark.NewDict(len(record))
+	for key, value := range record {
+		fields.SetKey(starlark.String(key), starlark.String(value))
+	}
+	labels := starlark.NewDict(len(d.meta.labels))
+	for key, value := range d.meta.labels {
+		labels.SetKey(starlark.String(key), starlark.String(value))
+	}
+	container := starlark.NewDict(4)
+	container.SetKey(starlark.String("id"), starlark.String(d.message.Container.ID))
+	container.SetKey(starlark.String("name"), starlark.String(d.message.Container.Name))
+	container.SetKey(starlark.String("image"), starlark.String(d.meta.image))
+	container.SetKey(starlark.String("labels"), labels)
+
+	thread := &starlark.Thread{Name: "transform"}
+	thread.SetMaxExecutionSteps(s.maxSteps)
+	result, err := starlark.Call(thread, s.transform, starlark.Tuple{fields, container}, nil)
+	if err != nil {
+		logSampledError("script:"+s.file, "Record script failed", "script", s.file, "error", err,
+			"container_id", d.message.Container.ID)
+		return record, true
+	}
+	if result == starlark.None {
+		return record, false
+	}
+	transformed, ok := result.(*starlark.Dict)
+	if !ok {
+		logSampledError("script:"+s.file, "Record script returned no dict", "script", s.file,
+			"type", result.Type())
+		return record, true
+	}
+
+	// Refill the pooled record rather than replacing it
+	for key := range record {
+		delete(record, key)
+	}
+	for _, item := range transformed.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			key = item[0].String()
+		}
+		value, ok := starlark.AsString(item[1])
+		if !ok {
+			value = item[1].String()
+		}
+		record[key] = value
+	}
+	return record, true
+}
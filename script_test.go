@@ -0,0 +1,87 @@
+package fluentd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestScript compiles a script with the given source.
+func newTestScript(t *testing.T, src string, maxSteps int) *recordScript {
+	file := filepath.Join(t.TempDir(), "transform.star")
+	if err := os.WriteFile(file, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newRecordScript(ScriptConfig{File: file, MaxSteps: maxSteps})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRecordScript(t *testing.T) {
+	original := map[string]string{"log": "hello", "source": "stdout"}
+	tests := []struct {
+		name     string
+		src      string
+		wantKeep bool
+		want     map[string]string
+	}{
+		{
+			name:     "add field",
+			src:      "def transform(record, container):\n    record[\"team\"] = container[\"labels\"].get(\"team\", \"unknown\")\n    return record\n",
+			wantKeep: true,
+			want:     map[string]string{"log": "hello", "source": "stdout", "team": "web"},
+		},
+		{
+			name:     "replace",
+			src:      "def transform(record, container):\n    return {\"log\": container[\"name\"], \"lines\": 1}\n",
+			wantKeep: true,
+			want:     map[string]string{"log": "web", "lines": "1"},
+		},
+		{name: "drop", src: "def transform(record, container):\n    return None\n", wantKeep: false, want: original},
+		// Failures leave the record unchanged
+		{name: "failure", src: "def transform(record, container):\n    fail(\"boom\")\n", wantKeep: true, want: original},
+		{name: "no dict", src: "def transform(record, container):\n    return 1\n", wantKeep: true, want: original},
+		{
+			name:     "steps exceeded",
+			src:      "def transform(record, container):\n    for i in range(1000000):\n        pass\n    return {}\n",
+			wantKeep: true,
+			want:     original,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestScript(t, test.src, 1000)
+			record := map[string]string{"log": "hello", "source": "stdout"}
+			d := &delivery{
+				message: testMessage("web", "hello", nil),
+				meta:    &containerMeta{image: "image:1", labels: map[string]string{"team": "web"}},
+			}
+
+			got, keep := s.middleware(d, record)
+			if keep != test.wantKeep {
+				t.Errorf("keep = %v, want %v", keep, test.wantKeep)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("record = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRecordScriptInvalid(t *testing.T) {
+	for name, src := range map[string]string{
+		"syntax":       "def transform(record, container)\n",
+		"no transform": "def other(record):\n    return record\n",
+	} {
+		file := filepath.Join(t.TempDir(), "transform.star")
+		if err := os.WriteFile(file, []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newRecordScript(ScriptConfig{File: file, MaxSteps: defaultScriptMaxSteps}); err == nil {
+			t.Errorf("%s: invalid script accepted", name)
+		}
+	}
+}
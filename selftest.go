@@ -0,0 +1,92 @@
+package fluentd
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// selftestField marks synthetic self-test records.
+const selftestField = "logspout_selftest"
+
+// selftestKey is the context key of the result of a self-test delivery.
+type selftestKey struct{}
+
+// selftestResult reports what happened to a self-test record. A record is
+// acked only when fluentd acknowledged it, which requires request_ack without
+// async; otherwise accepted means the fluent logger took it.
+type selftestResult struct {
+	Route    string `json:"route"`
+	ID       string `json:"id"`
+	Tag      string `json:"tag,omitempty"`
+	Accepted bool   `json:"accepted"`
+	Acked    bool   `json:"acked"`
+	Dropped  string `json:"dropped,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// selftest sends a synthetic record, marked with logspout_selftest=true,
+// through the full pipeline of the route and reports its outcome.
+func (ad *Adapter) selftest() *selftestResult {
+	now := time.Now()
+	result := &selftestResult{Route: ad.address, ID: "selftest-" + strconv.FormatInt(now.UnixNano(), 10)}
+	message := &router.Message{
+		Container: &docker.Container{
+			ID:     result.ID,
+			Name:   "/logspout-selftest",
+			Config: &docker.Config{Hostname: "logspout-selftest", Image: "logspout-selftest"},
+		},
+		Source: "stdout",
+		Data:   "logspout-fluentd self-test " + result.ID,
+		Time:   now,
+	}
+	ad.handle(context.WithValue(ad.ctx, selftestKey{}, result), message)
+	return result
+}
+
+// selftestDropped records the drop of a self-test record, if ctx is one.
+func selftestDropped(ctx context.Context, reason string) {
+	if result, ok := ctx.Value(selftestKey{}).(*selftestResult); ok {
+		result.Dropped = reason
+	}
+}
+
+// selftestMark marks the record of a self-test delivery, returning its result.
+func selftestMark(ctx context.Context, record map[string]string) *selftestResult {
+	result, ok := ctx.Value(selftestKey{}).(*selftestResult)
+	if !ok || record["container_id"] != result.ID {
+		return nil
+	}
+	record[selftestField] = "true"
+	return result
+}
+
+// posted records the outcome of posting the self-test record.
+func (r *selftestResult) posted(tag string, writer WriterConfig, err error) {
+	if r == nil {
+		return
+	}
+	r.Tag = tag
+	r.Accepted = err == nil
+	r.Acked = err == nil && writer.RequestAck && !writer.Async && !writer.DryRun
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// logSelftest logs the outcome of a self-test.
+func logSelftest(result *selftestResult) {
+	fields := []interface{}{"route", result.Route, "id", result.ID, "tag", result.Tag, "accepted", result.Accepted,
+		"acked", result.Acked}
+	switch {
+	case result.Dropped != "":
+		logWarn("Self-test record dropped", append(fields, "reason", result.Dropped)...)
+	case !result.Accepted:
+		logWarn("Self-test record failed", append(fields, "error", result.Error)...)
+	default:
+		logInfo("Self-test record forwarded", fields...)
+	}
+}
@@ -0,0 +1,103 @@
+package fluentd
+
+import (
+	"os"
+	"os/signal"
+	"runtime/pprof"
+	"sync"
+	"syscall"
+)
+
+// signalHandler handles the process signals on behalf of every adapter that
+// registered with it:
+//
+//	SIGHUP   reload the configuration
+//	SIGUSR1  log the counters of every route and dump all goroutines to stderr
+//	SIGTERM  close every adapter, draining queued records, then exit
+//
+// logspout itself leaves these signals to their default; once the adapters
+// are drained SIGTERM is re-raised with its default action, so the process
+// exits as it would have without the adapter. Only adapters created by
+// logspout register by default; HANDLE_SIGNALS=false leaves signals alone,
+// and programs embedding the package opt in with HANDLE_SIGNALS=true.
+type signalHandler struct {
+	mu       sync.Mutex
+	adapters map[*Adapter]bool
+}
+
+var (
+	signals     = &signalHandler{adapters: make(map[*Adapter]bool)}
+	signalsOnce sync.Once
+)
+
+// handleSignals registers an adapter with the signal handler, starting it on
+// first use.
+func handleSignals(ad *Adapter) {
+	signalsOnce.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGTERM)
+		go signals.run(ch)
+	})
+	signals.mu.Lock()
+	signals.adapters[ad] = true
+	signals.mu.Unlock()
+}
+
+// unhandleSignals removes a closed adapter from the signal handler.
+func unhandleSignals(ad *Adapter) {
+	signals.mu.Lock()
+	delete(signals.adapters, ad)
+	signals.mu.Unlock()
+}
+
+func (h *signalHandler) run(ch chan os.Signal) {
+	for sig := range ch {
+		switch sig {
+		case syscall.SIGHUP:
+			for _, ad := range h.registered() {
+				if ad.load == nil {
+					continue
+				}
+				if err := ad.reload(); err != nil {
+					logError("Config reload failed", "route", ad.address, "error", err)
+				}
+			}
+		case syscall.SIGUSR1:
+			for _, ad := range h.registered() {
+				logInfo("Route statistics", "route", ad.address, "stats", ad.metrics.vars.fields(ad.address))
+			}
+			pprof.Lookup("goroutine").WriteTo(os.Stderr, 2)
+		case syscall.SIGTERM:
+			h.terminate()
+		}
+	}
+}
+
+// terminate closes all adapters in parallel and re-raises SIGTERM.
+func (h *signalHandler) terminate() {
+	adapters := h.registered()
+	logInfo("Received SIGTERM, draining adapters", "routes", len(adapters))
+	var wg sync.WaitGroup
+	for _, ad := range adapters {
+		wg.Add(1)
+		go func(ad *Adapter) {
+			defer wg.Done()
+			ad.Close()
+		}(ad)
+	}
+	wg.Wait()
+
+	signal.Reset(syscall.SIGTERM)
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+}
+
+// registered returns the registered adapters.
+func (h *signalHandler) registered() []*Adapter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	adapters := make([]*Adapter, 0, len(h.adapters))
+	for ad := range h.adapters {
+		adapters = append(adapters, ad)
+	}
+	return adapters
+}
@@ -0,0 +1,103 @@
+package fluentd
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// States of an adapter. An adapter starts, connects to fluentd and streams;
+// it is degraded while posts to its fluentd fail, drains once closed and is
+// stopped when its writers are closed. The zero value is stateStarting.
+const (
+	stateStarting = iota
+	stateConnecting
+	stateStreaming
+	stateDegraded
+	stateDraining
+	stateStopped
+)
+
+var stateNames = []string{"starting", "connecting", "streaming", "degraded", "draining", "stopped"}
+
+var adapterState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "state",
+	Help:      "Run state of the route's adapter, 1 for the current state.",
+}, []string{"route", "state"})
+
+func init() {
+	prometheus.MustRegister(adapterState)
+}
+
+// runState is the run state of an adapter. Transitions are logged and
+// exported as the state gauge; the admin API serves the current state.
+type runState struct {
+	route string
+	state int32
+
+	mu    sync.Mutex
+	since time.Time
+}
+
+// newRunState creates the run state of the route to address, starting.
+func newRunState(address string) *runState {
+	s := &runState{route: address, since: time.Now()}
+	for state, name := range stateNames {
+		value := 0.0
+		if state == stateStarting {
+			value = 1
+		}
+		adapterState.WithLabelValues(address, name).Set(value)
+	}
+	return s
+}
+
+// set moves to state for the given reason. Stopped is final, and draining
+// only ends in stopped.
+func (s *runState) set(state int32, reason string) {
+	if atomic.LoadInt32(&s.state) == state {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := atomic.LoadInt32(&s.state)
+	if previous == state || previous == stateStopped || (previous == stateDraining && state != stateStopped) {
+		return
+	}
+	atomic.StoreInt32(&s.state, state)
+	now := time.Now()
+	logInfo("Adapter state changed", "route", s.route, "state", stateNames[state],
+		"previous", stateNames[previous], "reason", reason, "after", now.Sub(s.since))
+	s.since = now
+	adapterState.WithLabelValues(s.route, stateNames[previous]).Set(0)
+	adapterState.WithLabelValues(s.route, stateNames[state]).Set(1)
+}
+
+// streaming moves to streaming once the adapter started streaming.
+func (s *runState) streaming() {
+	if atomic.LoadInt32(&s.state) < stateStreaming {
+		s.set(stateStreaming, "stream")
+	}
+}
+
+// posted moves between streaming and degraded by the result of a post to
+// the route's fluentd.
+func (s *runState) posted(err error) {
+	switch current := atomic.LoadInt32(&s.state); {
+	case err != nil && current == stateStreaming:
+		s.set(stateDegraded, errorType(err))
+	case err == nil && current == stateDegraded:
+		s.set(stateStreaming, reasonPost)
+	}
+}
+
+// current returns the name of the current state and when it was entered.
+func (s *runState) current() (string, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return stateNames[atomic.LoadInt32(&s.state)], s.since
+}
@@ -0,0 +1,50 @@
+package fluentd
+
+import (
+	"context"
+	"expvar"
+	"time"
+)
+
+// StatsConfig configures the self-monitoring records of an adapter.
+type StatsConfig struct {
+	Interval time.Duration
+	Tag      string
+}
+
+// runStats posts the route's counters as a record every interval, so delivery
+// health is visible in the logging backend itself. The tag defaults to
+// <TAG_PREFIX>.logspout.stats. It stops when the adapter is closed.
+func (ad *Adapter) runStats(config StatsConfig) {
+	if config.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ad.postStats(config)
+		case <-ad.ctx.Done():
+			return
+		}
+	}
+}
+
+// postStats posts a record of the route's counters.
+func (ad *Adapter) postStats(config StatsConfig) {
+	tag := config.Tag
+	if tag == "" {
+		tag = ad.currentRules().tagPrefix + ".logspout.stats"
+	}
+	ad.post(context.Background(), ad.primary(), tag, time.Now(), ad.metrics.vars.fields(ad.address))
+}
+
+// fields returns the counters as record fields.
+func (v *routeVars) fields(route string) map[string]string {
+	record := map[string]string{"route": route}
+	v.counters.Do(func(counter expvar.KeyValue) {
+		record[counter.Key] = counter.Value.String()
+	})
+	return record
+}
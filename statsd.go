@@ -0,0 +1,108 @@
+package fluentd
+
+import (
+	"expvar"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStatsdPrefix   = "fluentd_adapter."
+	defaultStatsdInterval = 10
+
+	// statsdPacketSize keeps packets within a typical MTU.
+	statsdPacketSize = 1432
+)
+
+// StatsdConfig configures the emission of the route's counters to StatsD, as
+// an alternative to scraping METRICS_ADDRESS.
+type StatsdConfig struct {
+	Address   string
+	Prefix    string
+	Interval  time.Duration
+	DogStatsD bool
+}
+
+// statsdGauges are the route variables sent as gauges, all others are
+// counters sent as the increase since the last flush.
+var statsdGauges = map[string]bool{
+	"mirror_queue_depth": true,
+	"connected":          true,
+}
+
+// runStatsd sends the route's counters to StatsD every interval. The route is
+// a tag with DogStatsD and part of the metric name otherwise, e.g.
+// fluentd_adapter.fluentd_24224.records_forwarded.
+func (ad *Adapter) runStatsd(config StatsdConfig) {
+	if config.Address == "" || config.Interval <= 0 {
+		return
+	}
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		logError("Unable to emit to StatsD", "address", config.Address, "error", err)
+		return
+	}
+	defer conn.Close()
+
+	prefix, suffix := config.Prefix, ""
+	if config.DogStatsD {
+		suffix = "|#route:" + ad.address
+	} else {
+		prefix += statsdName(ad.address) + "."
+	}
+	last := make(map[string]int64)
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ad.ctx.Done():
+			return
+		}
+		var packet []byte
+		ad.metrics.vars.counters.Do(func(counter expvar.KeyValue) {
+			value, err := strconv.ParseInt(counter.Value.String(), 10, 64)
+			if err != nil {
+				return
+			}
+			line := prefix + counter.Key + ":"
+			if statsdGauges[counter.Key] {
+				line += strconv.FormatInt(value, 10) + "|g"
+			} else {
+				line += strconv.FormatInt(value-last[counter.Key], 10) + "|c"
+				last[counter.Key] = value
+			}
+			line += suffix
+			if len(packet) > 0 && len(packet)+1+len(line) > statsdPacketSize {
+				statsdSend(conn, packet)
+				packet = packet[:0]
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		})
+		if len(packet) > 0 {
+			statsdSend(conn, packet)
+		}
+	}
+}
+
+// statsdSend writes a packet, logging failures at a sampled rate.
+func statsdSend(conn net.Conn, packet []byte) {
+	if _, err := conn.Write(packet); err != nil {
+		logSampledError("statsd", "Unable to emit to StatsD", "error", err)
+	}
+}
+
+// statsdName turns a route address into a metric name segment.
+func statsdName(address string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, address)
+}
@@ -0,0 +1,50 @@
+package fluentd
+
+const defaultStreamFilterLabel = "fluentd.stream"
+
+// streamFilter restricts forwarding to one output stream ("stdout" or
+// "stderr"), globally via STREAM_FILTER or per container via a label.
+type streamFilter struct {
+	stream string
+	label  string
+	fixed  bool
+}
+
+// newStreamFilter creates a filter forwarding the given stream, unless the
+// label on a container selects another one. A fixed stream, given as a route
+// option, can't be overridden by containers. An empty stream, as in a Config
+// built without LoadConfig, forwards all streams.
+func newStreamFilter(stream, label string, fixed bool) *streamFilter {
+	if stream == "" {
+		stream = "all"
+	}
+	return &streamFilter{
+		stream: stream,
+		label:  label,
+		fixed:  fixed,
+	}
+}
+
+// allow reports whether a message from the given source may be forwarded for
+// a container with the given labels. A non-empty override, taken from the
+// container options, selects the stream regardless of the label.
+func (f *streamFilter) allow(source string, labels map[string]string, override string) bool {
+	stream := f.stream
+	if f.fixed {
+		return stream == "all" || stream == source
+	}
+	if override != "" {
+		stream = override
+	} else if value, found := labels[f.label]; found {
+		if validStream(value) {
+			stream = value
+		} else {
+			logDebug("Invalid stream filter label value", "label", f.label, "value", value)
+		}
+	}
+	return stream == "all" || stream == source
+}
+
+func validStream(stream string) bool {
+	return stream == "all" || stream == "stdout" || stream == "stderr"
+}
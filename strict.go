@@ -0,0 +1,53 @@
+package fluentd
+
+import "strings"
+
+// Values of STRICT_MODE, which controls what happens to a record when settings
+// that apply to it fail to parse or evaluate: container options from labels
+// and image bundles, and filter expressions. "off" forwards the record as if
+// the setting was absent, "annotate" forwards it with the failures in the
+// _parse_error field, "drop" discards it and "fail" exits logspout, which is
+// meant for CI and testing.
+const (
+	strictOff      = "off"
+	strictAnnotate = "annotate"
+	strictDrop     = "drop"
+	strictFail     = "fail"
+
+	parseErrorField = "_parse_error"
+)
+
+// parseFailures collects the failures that apply to a single record.
+type parseFailures []string
+
+func (p *parseFailures) add(err error) {
+	if err != nil {
+		*p = append(*p, err.Error())
+	}
+}
+
+// keep reports whether a record with these failures may be forwarded under
+// the given mode. In fail mode it does not return.
+func (p parseFailures) keep(mode string) bool {
+	if len(p) == 0 {
+		return true
+	}
+	switch mode {
+	case strictDrop:
+		return false
+	case strictFail:
+		logFatal("Parse failure with STRICT_MODE=fail", "error", p.String())
+	}
+	return true
+}
+
+// annotate adds the failures to a record in annotate mode.
+func (p parseFailures) annotate(mode string, record map[string]string) {
+	if len(p) > 0 && mode == strictAnnotate {
+		record[parseErrorField] = p.String()
+	}
+}
+
+func (p parseFailures) String() string {
+	return strings.Join(p, "; ")
+}
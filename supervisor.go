@@ -0,0 +1,77 @@
+package fluentd
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Bounds of the wait between attempts to recreate a writer.
+const (
+	minRestartBackoff = time.Second
+	maxRestartBackoff = time.Minute
+)
+
+var writerRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "writer_restarts_total",
+	Help:      "Writers of the route's fluentd recreated after the fluent logger gave up.",
+}, []string{"route"})
+
+func init() {
+	prometheus.MustRegister(writerRestarts)
+}
+
+// writerSupervisor recreates the writer of the route's own fluentd once the
+// fluent logger gave up connecting or writing after FLUENTD_MAX_RETRIES,
+// replacing a connection that may be wedged. Async writers report this
+// through their result callback. Attempts back off from CONNECTION_RETRY_WAIT,
+// but at least minRestartBackoff, up to maxRestartBackoff.
+type writerSupervisor struct {
+	restarts   prometheus.Counter
+	restarting int32
+}
+
+// newWriterSupervisor creates the supervisor of the writer of a route.
+func newWriterSupervisor(route string) *writerSupervisor {
+	return &writerSupervisor{restarts: writerRestarts.WithLabelValues(route)}
+}
+
+// superviseWriter restarts the primary writer in the background when err
+// means it failed for good. Only one restart runs at a time.
+func (ad *Adapter) superviseWriter(err error) {
+	if err == nil || errorType(err) != errorMaxRetries || ad.ctx.Err() != nil {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&ad.supervisor.restarting, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&ad.supervisor.restarting, 0)
+		backoff := ad.currentConfig().ConnectionRetryWait
+		if backoff < minRestartBackoff {
+			backoff = minRestartBackoff
+		}
+		for {
+			logWarn("Recreating fluentd writer", "address", ad.address, "error", err)
+			if err = ad.replaceWriter(); err == nil {
+				ad.supervisor.restarts.Inc()
+				ad.connections.emit(eventReconnect, errorMaxRetries)
+				return
+			}
+			logError("Unable to recreate fluentd writer", "address", ad.address, "error", err,
+				"wait", backoff)
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ad.ctx.Done():
+				timer.Stop()
+				return
+			}
+			if backoff *= 2; backoff > maxRestartBackoff {
+				backoff = maxRestartBackoff
+			}
+		}
+	}()
+}
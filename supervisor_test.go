@@ -0,0 +1,40 @@
+package fluentd
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSuperviseWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		closed      bool
+		wantRestart bool
+	}{
+		{name: "success"},
+		{name: "other error", err: errors.New("fluent#write: connection refused")},
+		{name: "gave up", err: errors.New("fluent#write: failed to write after 3 attempts"), wantRestart: true},
+		{name: "closed", err: errors.New("fluent#write: failed to write after 3 attempts"), closed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ad := newForwardAdapter(t, newFakeFluentd(t, nil, 0), nil)
+			previous := ad.primary()
+			if test.closed {
+				ad.Close()
+			}
+
+			ad.superviseWriter(test.err)
+			deadline := time.Now().Add(5 * time.Second)
+			for atomic.LoadInt32(&ad.supervisor.restarting) == 1 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if restarted := ad.primary() != previous; restarted != test.wantRestart {
+				t.Errorf("writer recreated = %v, want %v", restarted, test.wantRestart)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package fluentd
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultTagStatsTop = 50
+
+var (
+	tagRecords = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tag_records",
+		Help:      "Records forwarded for the top tags by volume, estimated.",
+	}, []string{"route", "tag"})
+	tagBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tag_bytes",
+		Help:      "Bytes of log lines forwarded for the top tags by volume, estimated.",
+	}, []string{"route", "tag"})
+)
+
+func init() {
+	prometheus.MustRegister(tagRecords, tagBytes)
+}
+
+// tagStat is the volume forwarded for a tag. Error bounds the overestimate of
+// the counts inherited from an evicted tag.
+type tagStat struct {
+	Tag     string `json:"tag"`
+	Records int64  `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	Error   int64  `json:"error_bytes"`
+
+	records prometheus.Gauge
+	bytes   prometheus.Gauge
+}
+
+// tagVolume accounts forwarded records and bytes per tag for the top tags by
+// bytes, using the space-saving algorithm: when all slots are taken, a new tag
+// replaces the smallest one and inherits its counts. Tags dominating the
+// volume are thereby always tracked, with bounded metrics cardinality.
+type tagVolume struct {
+	route string
+	top   int
+
+	mu   sync.Mutex
+	tags map[string]*tagStat
+}
+
+// newTagVolume creates the accounting of the top tags of a route.
+func newTagVolume(route string, top int) *tagVolume {
+	return &tagVolume{route: route, top: top, tags: make(map[string]*tagStat)}
+}
+
+// forwarded accounts a record of the given size forwarded under the tag.
+func (v *tagVolume) forwarded(tag string, size int) {
+	if v.top <= 0 {
+		return
+	}
+
+	v.mu.Lock()
+	stat, found := v.tags[tag]
+	if !found {
+		stat = &tagStat{
+			Tag:     tag,
+			records: tagRecords.WithLabelValues(v.route, tag),
+			bytes:   tagBytes.WithLabelValues(v.route, tag),
+		}
+		if len(v.tags) >= v.top {
+			smallest := v.smallest()
+			delete(v.tags, smallest.Tag)
+			tagRecords.DeleteLabelValues(v.route, smallest.Tag)
+			tagBytes.DeleteLabelValues(v.route, smallest.Tag)
+			stat.Records, stat.Bytes, stat.Error = smallest.Records, smallest.Bytes, smallest.Bytes
+		}
+		v.tags[tag] = stat
+	}
+	stat.Records++
+	stat.Bytes += int64(size)
+	records, bytes := stat.Records, stat.Bytes
+	v.mu.Unlock()
+
+	stat.records.Set(float64(records))
+	stat.bytes.Set(float64(bytes))
+}
+
+// smallest returns the tag with the fewest bytes. The caller holds the lock.
+func (v *tagVolume) smallest() *tagStat {
+	var smallest *tagStat
+	for _, stat := range v.tags {
+		if smallest == nil || stat.Bytes < smallest.Bytes {
+			smallest = stat
+		}
+	}
+	return smallest
+}
+
+// snapshot returns the tracked tags, largest first.
+func (v *tagVolume) snapshot() []tagStat {
+	v.mu.Lock()
+	stats := make([]tagStat, 0, len(v.tags))
+	for _, stat := range v.tags {
+		stats = append(stats, *stat)
+	}
+	v.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Bytes != stats[j].Bytes {
+			return stats[i].Bytes > stats[j].Bytes
+		}
+		return stats[i].Tag < stats[j].Tag
+	})
+	return stats
+}
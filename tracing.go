@@ -0,0 +1,128 @@
+package fluentd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultTraceSampleRate = 1000
+	tracerName             = "github.com/Kouba91/logspout-fluentd-test"
+)
+
+// TracingConfig configures the OpenTelemetry tracing of deliveries.
+type TracingConfig struct {
+	Endpoint   string
+	Insecure   bool
+	SampleRate int
+}
+
+var (
+	tracerProvidersMu sync.Mutex
+	tracerProviders   = make(map[string]trace.TracerProvider)
+)
+
+// deliveryTracer traces the delivery of sampled messages: a "deliver" span
+// from the time the container wrote the line, with the stages "receive" (until
+// the adapter got it from logspout), "transform" (filters, admission and the
+// record), "post" (PostWithTime, which only enqueues into the fluent logger's
+// buffer in async mode) and "enqueue" (into the mirror queue). Without a
+// tracer nothing is recorded, and nothing is allocated per message either.
+type deliveryTracer struct {
+	tracer trace.Tracer
+}
+
+// newDeliveryTracer creates a tracer exporting to the configured endpoint, or
+// one that records nothing when tracing is disabled. Routes with the same
+// endpoint share an exporter, whose sample rate is the first route's.
+func newDeliveryTracer(config TracingConfig) (*deliveryTracer, error) {
+	if config.Endpoint == "" {
+		return &deliveryTracer{}, nil
+	}
+
+	tracerProvidersMu.Lock()
+	defer tracerProvidersMu.Unlock()
+
+	provider, found := tracerProviders[config.Endpoint]
+	if !found {
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(context.Background(), options...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to create trace exporter for %s", config.Endpoint)
+		}
+		sampler := sdktrace.TraceIDRatioBased(1 / float64(config.SampleRate))
+		provider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+			sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "logspout-fluentd"))),
+		)
+		tracerProviders[config.Endpoint] = provider
+		logInfo("Tracing deliveries", "endpoint", config.Endpoint, "sample_rate", config.SampleRate)
+	}
+	return &deliveryTracer{tracer: provider.Tracer(tracerName)}, nil
+}
+
+// startDelivery starts the span of a message's delivery and records its
+// receive stage.
+func (t *deliveryTracer) startDelivery(ctx context.Context, route string,
+	message *router.Message) (context.Context, trace.Span) {
+	if t.tracer == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	ctx, span := t.tracer.Start(ctx, "deliver",
+		trace.WithTimestamp(message.Time),
+		trace.WithAttributes(
+			attribute.String("route", route),
+			attribute.String("container_name", message.Container.Name),
+			attribute.String("container_id", message.Container.ID),
+			attribute.String("source", message.Source),
+		))
+	if span.IsRecording() {
+		_, receive := t.tracer.Start(ctx, "receive", trace.WithTimestamp(message.Time))
+		receive.End(trace.WithTimestamp(time.Now()))
+	}
+	return ctx, span
+}
+
+// stage starts the span of a delivery stage, for the given destination if
+// not "". Outside of a sampled delivery, e.g. for reports and stats, it
+// returns a span that records nothing.
+func (t *deliveryTracer) stage(ctx context.Context, name, destination string) trace.Span {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.IsRecording() {
+		return parent
+	}
+	if destination == "" {
+		_, span := t.tracer.Start(ctx, name)
+		return span
+	}
+	_, span := t.tracer.Start(ctx, name, trace.WithAttributes(attribute.String("destination", destination)))
+	return span
+}
+
+// traceDropped marks the delivery in ctx as discarded for the given reason.
+func traceDropped(ctx context.Context, reason string) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("drop_reason", reason))
+}
+
+// traceFailed marks a span as failed with the given error, if any.
+func traceFailed(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, errorType(err))
+}
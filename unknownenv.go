@@ -0,0 +1,119 @@
+package fluentd
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// envScanPrefixes are the prefixes of environment variables meant for this
+// adapter. Variables with these prefixes that no setting reads are most
+// likely typos, e.g. FLUENTD_ASYNC_CONECT.
+var envScanPrefixes = []string{"FLUENTD_", "TAG_", "CONNECTION_"}
+
+// warnUnknownEnv logs a warning for every variable with an adapter prefix that
+// is not a known setting, suggesting the closest known name.
+func warnUnknownEnv(envPrefix string) {
+	for _, name := range unknownEnv(os.Environ(), envPrefix) {
+		setting := strings.TrimSuffix(name, fileSuffix)
+		if suggestion := closestEnv(setting); suggestion != "" {
+			logWarn("Unknown environment variable is ignored", "variable", name,
+				"suggestion", suggestion+strings.TrimPrefix(name, setting))
+		} else {
+			logWarn("Unknown environment variable is ignored", "variable", name)
+		}
+	}
+}
+
+// unknownEnv returns the names of variables in environ with an adapter prefix
+// that no setting reads, in order.
+func unknownEnv(environ []string, envPrefix string) []string {
+	known := knownEnv()
+	var unknown []string
+	for _, entry := range environ {
+		name := strings.SplitN(entry, "=", 2)[0]
+		setting := strings.TrimSuffix(name, fileSuffix)
+		if envPrefix != "" {
+			setting = strings.TrimPrefix(setting, envPrefix)
+		}
+		if known[setting] || !hasEnvScanPrefix(setting) {
+			continue
+		}
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+var (
+	knownEnvOnce sync.Once
+	knownEnvSet  map[string]bool
+)
+
+// knownEnv returns the set of variables read by settings and their aliases,
+// built once from the schema.
+func knownEnv() map[string]bool {
+	knownEnvOnce.Do(func() {
+		knownEnvSet = make(map[string]bool)
+		for _, option := range Schema() {
+			knownEnvSet[option.Env] = true
+		}
+		for _, alias := range envAliases {
+			knownEnvSet[alias] = true
+		}
+	})
+	return knownEnvSet
+}
+
+func hasEnvScanPrefix(name string) bool {
+	for _, prefix := range envScanPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestEnv returns the known variable closest to name, if it is close enough
+// to be a likely typo.
+func closestEnv(name string) string {
+	closest, best := "", len(name)/3+1
+	for candidate := range knownEnv() {
+		if d := editDistance(name, candidate); d < best {
+			closest, best = candidate, d
+		}
+	}
+	return closest
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,50 @@
+package fluentd
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Version and Commit identify the build of the adapter. They are set when
+// building logspout, e.g.
+//
+//	go build -ldflags "-X github.com/Kouba91/logspout-fluentd-test.Version=v1.2.0 \
+//		-X github.com/Kouba91/logspout-fluentd-test.Commit=$(git rev-parse HEAD)"
+//
+// and otherwise taken from the module and VCS information of the binary.
+var (
+	Version = ""
+	Commit  = ""
+)
+
+var buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "build_info",
+	Help:      "Build of the adapter, always 1.",
+}, []string{"version", "commit", "goversion"})
+
+func init() {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/Kouba91/logspout-fluentd-test" && Version == "" {
+				Version = dep.Version
+			}
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && Commit == "" {
+				Commit = setting.Value
+			}
+		}
+	}
+	if Version == "" {
+		Version = "dev"
+	}
+	if Commit == "" {
+		Commit = "unknown"
+	}
+
+	prometheus.MustRegister(buildInfo)
+	buildInfo.WithLabelValues(Version, Commit, runtime.Version()).Set(1)
+}
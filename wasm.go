@@ -0,0 +1,195 @@
+package fluentd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const (
+	defaultWasmTimeout = 100 * time.Millisecond
+
+	// Results of transform besides a packed pointer and length.
+	wasmKeep = 0
+	wasmDrop = ^uint64(0)
+)
+
+// WasmConfig configures the optional WASM record filter.
+type WasmConfig struct {
+	File    string
+	Timeout time.Duration
+}
+
+// wasmFilter mutates and filters records with a sandboxed WASM module given
+// by WASM_FILE, written in any language compiling to WASM. The module exports
+// its memory and
+//
+//	alloc(size u32) u32              reserve size bytes for the input
+//	transform(ptr u32, len u32) u64  process the input at ptr
+//
+// The input is the record as a JSON object of strings. transform returns 0 to
+// post the record unchanged, all bits set to drop the message, or the
+// pointer to a JSON object replacing the record in the upper and its length
+// in the lower 32 bits. A call may take up to WASM_TIMEOUT (default 100ms, 0 for no limit).
+// A call that fails, times out or returns anything but a JSON object of
+// strings leaves the record unchanged.
+//
+// The module is compiled once when the adapter is created and released when
+// it is closed. Instances are not safe for concurrent use, so each worker
+// takes one from a pool.
+type wasmFilter struct {
+	file     string
+	timeout  time.Duration
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	pool     sync.Pool
+}
+
+// newWasmFilter compiles the module of the configuration. It returns nil when
+// no module is configured.
+func newWasmFilter(config WasmConfig) (*wasmFilter, error) {
+	if config.File == "" {
+		return nil, nil
+	}
+	binary, err := os.ReadFile(config.File)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read WASM_FILE")
+	}
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	compiled, err := runtime.CompileModule(ctx, binary)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, errors.Wrapf(err, "Invalid WASM_FILE %s", config.File)
+	}
+	f := &wasmFilter{file: config.File, timeout: config.Timeout, runtime: runtime, compiled: compiled}
+
+	// Check the exports once, and keep the instance for the first call
+	module, err := f.instantiate(ctx)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	f.pool.Put(module)
+	return f, nil
+}
+
+// instantiate creates an instance of the module.
+func (f *wasmFilter) instantiate(ctx context.Context) (api.Module, error) {
+	module, err := f.runtime.InstantiateModule(ctx, f.compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to instantiate WASM_FILE %s", f.file)
+	}
+	if module.Memory() == nil || module.ExportedFunction("alloc") == nil ||
+		module.ExportedFunction("transform") == nil {
+		module.Close(ctx)
+		return nil, errors.Errorf("WASM_FILE %s must export memory, alloc and transform", f.file)
+	}
+	return module, nil
+}
+
+// middleware returns the module as a middleware.
+func (f *wasmFilter) middleware(d *delivery, record map[string]string) (map[string]string, bool) {
+	output, keep, err := f.call(record)
+	if err != nil {
+		logSampledError("wasm:"+f.file, "WASM filter failed", "module", f.file, "error", err,
+			"container_id", d.message.Container.ID)
+		return record, true
+	}
+	if !keep || output == nil {
+		return record, keep
+	}
+
+	// Decode into a new map, so an invalid record leaves the pooled one as is
+	var replaced map[string]string
+	if err := json.Unmarshal(output, &replaced); err != nil || replaced == nil {
+		if err == nil {
+			err = errors.New("transform returned null")
+		}
+		logSampledError("wasm:"+f.file, "WASM filter returned an invalid record", "module", f.file,
+			"error", err, "container_id", d.message.Container.ID)
+		return record, true
+	}
+
+	// Refill the pooled record rather than replacing it
+	for key := range record {
+		delete(record, key)
+	}
+	for key, value := range replaced {
+		record[key] = value
+	}
+	return record, true
+}
+
+// close releases the runtime and the compiled module.
+func (f *wasmFilter) close() {
+	if f == nil {
+		return
+	}
+	f.runtime.Close(context.Background())
+}
+
+// call runs transform on the record, returning the replacing record, if any,
+// and whether to keep the message.
+func (f *wasmFilter) call(record map[string]string) ([]byte, bool, error) {
+	input, err := json.Marshal(record)
+	if err != nil {
+		return nil, true, err
+	}
+	ctx := context.Background()
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	module, _ := f.pool.Get().(api.Module)
+	if module == nil {
+		if module, err = f.instantiate(context.Background()); err != nil {
+			return nil, true, err
+		}
+	}
+
+	results, err := module.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		// A timed out or trapped instance is closed or in an unknown state
+		module.Close(context.Background())
+		return nil, true, errors.Wrapf(err, "alloc")
+	}
+	ptr := uint32(results[0])
+	if !module.Memory().Write(ptr, input) {
+		module.Close(context.Background())
+		return nil, true, errors.New("alloc returned memory out of range")
+	}
+	results, err = module.ExportedFunction("transform").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		module.Close(context.Background())
+		return nil, true, errors.Wrapf(err, "transform")
+	}
+
+	var output []byte
+	switch result := results[0]; result {
+	case wasmKeep:
+	case wasmDrop:
+		f.pool.Put(module)
+		return nil, false, nil
+	default:
+		data, ok := module.Memory().Read(uint32(result>>32), uint32(result))
+		if !ok {
+			module.Close(context.Background())
+			return nil, true, errors.New("transform returned memory out of range")
+		}
+		// Copy before the instance is reused
+		output = append([]byte(nil), data...)
+	}
+	f.pool.Put(module)
+	return output, true, nil
+}
@@ -0,0 +1,165 @@
+package fluentd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// wasmModule returns a module whose transform returns result, and which holds
+// output in its memory at wasmOutputOffset.
+func wasmModule(result int64, output string) []byte {
+	section := func(id byte, content ...byte) []byte {
+		return append(append([]byte{id}, uleb128(uint64(len(content)))...), content...)
+	}
+	name := func(s string) []byte {
+		return append(uleb128(uint64(len(s))), s...)
+	}
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	// alloc (i32) -> i32 and transform (i32, i32) -> i64
+	module = append(module, section(1, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e)...)
+	module = append(module, section(3, 0x02, 0x00, 0x01)...)
+	module = append(module, section(5, 0x01, 0x00, 0x01)...)
+
+	exports := []byte{0x03}
+	exports = append(append(exports, name("memory")...), 0x02, 0x00)
+	exports = append(append(exports, name("alloc")...), 0x00, 0x00)
+	exports = append(append(exports, name("transform")...), 0x00, 0x01)
+	module = append(module, section(7, exports...)...)
+
+	// alloc returns 1024, transform returns result
+	alloc := append([]byte{0x00, 0x41}, sleb128(1024)...)
+	alloc = append(alloc, 0x0b)
+	transform := append([]byte{0x00, 0x42}, sleb128(result)...)
+	transform = append(transform, 0x0b)
+	code := []byte{0x02}
+	code = append(append(code, uleb128(uint64(len(alloc)))...), alloc...)
+	code = append(append(code, uleb128(uint64(len(transform)))...), transform...)
+	module = append(module, section(10, code...)...)
+
+	data := append([]byte{0x01, 0x00, 0x41}, sleb128(wasmOutputOffset)...)
+	data = append(data, 0x0b)
+	data = append(append(data, uleb128(uint64(len(output)))...), output...)
+	return append(module, section(11, data...)...)
+}
+
+// wasmOutputOffset is where wasmModule places its output.
+const wasmOutputOffset = 2048
+
+// wasmReplace returns the transform result replacing the record with output.
+func wasmReplace(output string) int64 {
+	return wasmOutputOffset<<32 | int64(len(output))
+}
+
+func uleb128(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func sleb128(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// newTestWasmFilter compiles a module returning result and output.
+func newTestWasmFilter(t *testing.T, result int64, output string) *wasmFilter {
+	file := filepath.Join(t.TempDir(), "filter.wasm")
+	if err := os.WriteFile(file, wasmModule(result, output), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := newWasmFilter(WasmConfig{File: file, Timeout: defaultWasmTimeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(f.close)
+	return f
+}
+
+func TestWasmFilter(t *testing.T) {
+	original := map[string]string{"log": "hello", "source": "stdout"}
+	tests := []struct {
+		name     string
+		result   int64
+		output   string
+		wantKeep bool
+		want     map[string]string
+	}{
+		{name: "keep", result: 0, wantKeep: true, want: original},
+		{name: "drop", result: -1, wantKeep: false, want: original},
+		{
+			name:     "replace",
+			result:   wasmReplace(`{"log":"changed","team":"web"}`),
+			output:   `{"log":"changed","team":"web"}`,
+			wantKeep: true,
+			want:     map[string]string{"log": "changed", "team": "web"},
+		},
+		// Invalid records leave the record unchanged rather than partly
+		// replaced
+		{name: "invalid json", result: wasmReplace(`{"log":`), output: `{"log":`, wantKeep: true, want: original},
+		{
+			name:     "non-string value",
+			result:   wasmReplace(`{"log":"changed","level":3}`),
+			output:   `{"log":"changed","level":3}`,
+			wantKeep: true,
+			want:     original,
+		},
+		{name: "null", result: wasmReplace("null"), output: "null", wantKeep: true, want: original},
+		{name: "empty", result: 1 << 40, wantKeep: true, want: original},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newTestWasmFilter(t, test.result, test.output)
+			record := map[string]string{"log": "hello", "source": "stdout"}
+			d := &delivery{message: testMessage("web", "hello", nil)}
+
+			got, keep := f.middleware(d, record)
+			if keep != test.wantKeep {
+				t.Errorf("keep = %v, want %v", keep, test.wantKeep)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("record = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWasmFilterInvalidModule(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "filter.wasm")
+	if err := os.WriteFile(file, []byte("not wasm"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newWasmFilter(WasmConfig{File: file}); err == nil {
+		t.Error("newWasmFilter succeeded for an invalid module")
+	}
+}
+
+func TestWasmFilterClosedWithAdapter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "filter.wasm")
+	if err := os.WriteFile(file, wasmModule(0, ""), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ad := newTestAdapter(t, map[string]string{"wasm_file": file}, &fakePoster{})
+	if err := ad.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ad.pipeline.wasm.instantiate(context.Background()); err == nil {
+		t.Error("module instantiated after Close, the runtime was not closed")
+	}
+}
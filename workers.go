@@ -0,0 +1,108 @@
+package fluentd
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const defaultWorkerQueueSize = 1024
+
+// WorkersConfig configures the workers handling the messages of a route and
+// the buffers in front of them.
+type WorkersConfig struct {
+	Count     int
+	QueueSize int
+
+	// StreamBuffer is the number of messages taken from logspout ahead of
+	// handling, so short aggregator hiccups don't block logspout.
+	StreamBuffer int
+}
+
+// bufferStream returns a channel that takes up to size messages from
+// logstream ahead of the consumer, until done is closed.
+func bufferStream(logstream chan *router.Message, size int, done <-chan struct{}) chan *router.Message {
+	buffered := make(chan *router.Message, size)
+	go func() {
+		defer close(buffered)
+		for {
+			select {
+			case message, ok := <-logstream:
+				if !ok {
+					return
+				}
+				select {
+				case buffered <- message:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return buffered
+}
+
+// streamWorkers handles messages with several workers, so filtering, encoding
+// and posting use more than one core. Messages are sharded by container, so
+// the lines of a container are still handled and posted in order.
+func (ad *Adapter) streamWorkers(logstream chan *router.Message, config WorkersConfig) {
+	var wg sync.WaitGroup
+	queues := make([]chan *router.Message, config.Count)
+	watches := make([]*queueWatch, config.Count)
+	queueWarn := ad.currentConfig().QueueWarn
+	for i := range queues {
+		queues[i] = make(chan *router.Message, config.QueueSize)
+		watches[i] = newQueueWatch(ad.address, "worker "+strconv.Itoa(i), ad.address, config.QueueSize, queueWarn)
+		wg.Add(1)
+		go func(queue chan *router.Message) {
+			defer wg.Done()
+			for message := range queue {
+				ad.handle(ad.ctx, message)
+			}
+		}(queues[i])
+	}
+
+	ad.dispatch(logstream, queues, watches)
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+}
+
+// dispatch hands messages to the queues of their workers until logstream or
+// the adapter is closed, observing the depth of each queue with its watch.
+func (ad *Adapter) dispatch(logstream chan *router.Message, queues []chan *router.Message,
+	watches []*queueWatch) {
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			if debugEnabled() {
+				logDebug("Received message", "container_id", message.Container.ID,
+					"container_name", message.Container.Name)
+			}
+			worker := shard(message.Container.ID, len(queues))
+			select {
+			case queues[worker] <- message:
+				watches[worker].observe(len(queues[worker]))
+			case <-ad.ctx.Done():
+				return
+			}
+		case <-ad.ctx.Done():
+			return
+		}
+	}
+}
+
+// shard returns the worker of a container.
+func shard(id string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(workers))
+}